@@ -0,0 +1,102 @@
+// gatekeeper2settings converts the K8sPSPForbiddenSysctls constraints of OPA
+// Gatekeeper into settings of the sysctl-psp policy.
+//
+// Usage:
+//
+//	gatekeeper2settings [-o yaml|json] [FILE...]
+//
+// The constraints are read from the given files, or from the standard input.
+// The settings are written to the standard output, one YAML document or JSON
+// object for each constraint. The differences between the constraints and
+// the settings are reported on the standard error. The command fails when the
+// settings are not valid for the policy.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kubewarden/go-policy-template/gatekeeper"
+	"github.com/kubewarden/go-policy-template/internal/convert"
+	"sigs.k8s.io/yaml"
+)
+
+func main() {
+	output := flag.String("o", "yaml", "output format, either yaml or json")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-o yaml|json] [FILE...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(flag.Args(), *output, os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(files []string, output string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if output != "yaml" && output != "json" {
+		return fmt.Errorf("unknown output format %q, must be either yaml or json", output)
+	}
+
+	inputs := map[string][]byte{}
+	names := []string{}
+	if len(files) == 0 {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("cannot read the standard input: %w", err)
+		}
+		inputs["-"] = data
+		names = append(names, "-")
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		inputs[file] = data
+		names = append(names, file)
+	}
+
+	first := true
+	for _, name := range names {
+		results, err := gatekeeper.Convert(inputs[name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		for _, result := range results {
+			for _, untranslated := range result.Untranslated {
+				fmt.Fprintf(stderr, "%s: %s %s: %s\n", name, gatekeeper.Kind, result.Name, untranslated)
+			}
+			if err := convert.Validate(result.Settings); err != nil {
+				return fmt.Errorf("%s: %s %s: %w", name, gatekeeper.Kind, result.Name, err)
+			}
+
+			if output == "json" {
+				raw, err := json.Marshal(result.Settings)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(stdout, string(raw))
+				continue
+			}
+
+			raw, err := yaml.Marshal(result.Settings)
+			if err != nil {
+				return err
+			}
+			if !first {
+				fmt.Fprintln(stdout, "---")
+			}
+			fmt.Fprintf(stdout, "# converted from %s %s\n%s", gatekeeper.Kind, result.Name, raw)
+			first = false
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+// scc2settings converts OpenShift SecurityContextConstraints objects into
+// settings of the sysctl-psp policy.
+//
+// Usage:
+//
+//	scc2settings [-o yaml|json] [-scoped] [FILE...]
+//
+// The SecurityContextConstraints objects are read from the given files, or
+// from the standard input. The settings are written to the standard output,
+// one YAML document or JSON object for each SecurityContextConstraints. With
+// -scoped, all the SecurityContextConstraints are merged into a single
+// settings instance, whose allowedUnsafeSysctls are scoped to the service
+// accounts the SecurityContextConstraints are granted to. The features of the
+// SecurityContextConstraints that could not be translated are reported on the
+// standard error. The command fails when the settings are not valid for the
+// policy.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kubewarden/go-policy-template/internal/convert"
+	"github.com/kubewarden/go-policy-template/scc"
+	"sigs.k8s.io/yaml"
+)
+
+func main() {
+	output := flag.String("o", "yaml", "output format, either yaml or json")
+	scoped := flag.Bool("scoped", false, "merge all the SecurityContextConstraints into a single settings instance")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-o yaml|json] [-scoped] [FILE...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(flag.Args(), *output, *scoped, os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(files []string, output string, scoped bool, stdin io.Reader, stdout, stderr io.Writer) error {
+	if output != "yaml" && output != "json" {
+		return fmt.Errorf("unknown output format %q, must be either yaml or json", output)
+	}
+
+	data := []byte{}
+	if len(files) == 0 {
+		input, err := io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("cannot read the standard input: %w", err)
+		}
+		data = input
+	}
+	for _, file := range files {
+		input, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		// the files are merged into a single YAML stream, the scoped
+		// settings depend on all the SecurityContextConstraints
+		data = append(data, []byte("\n---\n")...)
+		data = append(data, input...)
+	}
+
+	if scoped {
+		result, err := scc.ConvertScoped(data)
+		if err != nil {
+			return err
+		}
+		for _, untranslated := range result.Untranslated {
+			fmt.Fprintln(stderr, untranslated)
+		}
+		if err := convert.Validate(result.Settings); err != nil {
+			return fmt.Errorf("merged from all the %s: %w", scc.Kind, err)
+		}
+		return write(stdout, output, result.Settings, "# merged from all the "+scc.Kind+"\n")
+	}
+
+	results, err := scc.Convert(data)
+	if err != nil {
+		return err
+	}
+	for i, result := range results {
+		for _, untranslated := range result.Untranslated {
+			fmt.Fprintf(stderr, "%s %s: %s\n", scc.Kind, result.Name, untranslated)
+		}
+		if err := convert.Validate(result.Settings); err != nil {
+			return fmt.Errorf("%s %s: %w", scc.Kind, result.Name, err)
+		}
+		header := fmt.Sprintf("# converted from %s %s\n", scc.Kind, result.Name)
+		if i != 0 && output == "yaml" {
+			header = "---\n" + header
+		}
+		if err := write(stdout, output, result.Settings, header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes the settings in the given format, the YAML ones are preceded
+// by header.
+func write(stdout io.Writer, output string, settings interface{}, header string) error {
+	if output == "json" {
+		raw, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, string(raw))
+		return nil
+	}
+
+	raw, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s%s", header, raw)
+	return nil
+}
@@ -0,0 +1,288 @@
+// sysctl-psp evaluates Kubernetes manifests against settings of the
+// sysctl-psp policy, using the same decisions of the policy.
+//
+// Usage:
+//
+//	sysctl-psp lint -settings FILE [-namespace NAMESPACE] [PATH...]
+//	sysctl-psp vap -settings FILE [-name NAME]
+//
+// lint reads the manifests from the given files and directories, or from
+// the standard input. YAML and JSON are accepted, including multiple YAML
+// documents and lists. The Pods and the workloads, like Deployments and
+// CronJobs, are evaluated as if they were created. The violations are
+// written to the standard output, and the command exits with 1 when any is
+// found. The objects without a namespace are evaluated in NAMESPACE.
+//
+// vap writes the ValidatingAdmissionPolicy equivalent to the settings, and
+// its binding. The settings that cannot be exported are reported on the
+// standard error.
+//
+// Both exit with 2 on errors, like unreadable files or invalid settings.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	onelog "github.com/francoispqt/onelog"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kubewarden/go-policy-template/manifest"
+	"github.com/kubewarden/go-policy-template/policy"
+)
+
+const (
+	exitViolations = 1
+	exitError      = 2
+)
+
+// workloadKinds are the kinds of the objects evaluated by lint, the ones
+// supported by policy.Evaluate.
+var workloadKinds = map[string]bool{
+	"Pod":                   true,
+	"Deployment":            true,
+	"ReplicaSet":            true,
+	"StatefulSet":           true,
+	"DaemonSet":             true,
+	"ReplicationController": true,
+	"Job":                   true,
+	"CronJob":               true,
+}
+
+func main() {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n"+
+			"  %[1]s lint -settings FILE [-namespace NAMESPACE] [PATH...]\n"+
+			"  %[1]s vap -settings FILE [-name NAME]\n", os.Args[0])
+	}
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitError)
+	}
+
+	// the logs of the policy are meant for the Kubewarden host
+	policy.SetLogger(onelog.New(io.Discard, 0))
+
+	switch os.Args[1] {
+	case "lint":
+		os.Exit(lint(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	case "vap":
+		os.Exit(exportValidatingAdmissionPolicy(os.Args[2:], os.Stdout, os.Stderr))
+	default:
+		usage()
+		os.Exit(exitError)
+	}
+}
+
+// lint runs the lint command, it returns the exit code.
+func lint(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	settingsFile := flags.String("settings", "", "the settings file, either YAML or JSON")
+	namespace := flags.String("namespace", "default", "the namespace of the objects without one")
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+
+	settings, err := readSettings(*settingsFile, stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	inputs, err := readManifests(flags.Args(), stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	violations := 0
+	for _, input := range inputs {
+		objects, err := manifest.Decode(input.data)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", input.name, err)
+			return exitError
+		}
+
+		for _, object := range objects {
+			if !workloadKinds[object.Kind] {
+				continue
+			}
+			metadata := struct {
+				Metadata struct {
+					Name      string `json:"name"`
+					Namespace string `json:"namespace"`
+				} `json:"metadata"`
+			}{}
+			if err := json.Unmarshal(object.Raw, &metadata); err != nil {
+				fmt.Fprintf(stderr, "%s: %s: %v\n", input.name, object.Kind, err)
+				return exitError
+			}
+			if metadata.Metadata.Namespace == "" {
+				metadata.Metadata.Namespace = *namespace
+			}
+
+			decision := policy.Evaluate(&settings, kubewarden_protocol.KubernetesAdmissionRequest{
+				Kind:      kubewarden_protocol.GroupVersionKind{Kind: object.Kind},
+				Name:      metadata.Metadata.Name,
+				Namespace: metadata.Metadata.Namespace,
+				Operation: "CREATE",
+				Object:    object.Raw,
+			})
+
+			prefix := fmt.Sprintf("%s: %s %s/%s", input.name, object.Kind,
+				metadata.Metadata.Namespace, metadata.Metadata.Name)
+			for _, warning := range decision.Warnings {
+				fmt.Fprintf(stdout, "%s: warning: %s\n", prefix, warning)
+			}
+			if decision.Accepted {
+				continue
+			}
+			if len(decision.Violations) == 0 {
+				// the object is not well formed
+				fmt.Fprintf(stdout, "%s: %s\n", prefix, decision.Message)
+				violations++
+			}
+			for _, violation := range decision.Violations {
+				fmt.Fprintf(stdout, "%s: %s\n", prefix, violation.Message)
+				violations++
+			}
+		}
+	}
+
+	switch violations {
+	case 0:
+		return 0
+	case 1:
+		fmt.Fprintln(stderr, "1 violation found")
+	default:
+		fmt.Fprintf(stderr, "%d violations found\n", violations)
+	}
+	return exitViolations
+}
+
+// exportValidatingAdmissionPolicy runs the vap command, it returns the exit
+// code.
+func exportValidatingAdmissionPolicy(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("vap", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	settingsFile := flags.String("settings", "", "the settings file, either YAML or JSON")
+	name := flags.String("name", "sysctl-psp", "the name of the ValidatingAdmissionPolicy and of its binding")
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+
+	settings, err := readSettings(*settingsFile, stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	validatingAdmissionPolicy, binding, unexported, err := settings.ExportValidatingAdmissionPolicy(*name)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	for _, feature := range unexported {
+		fmt.Fprintln(stderr, feature)
+	}
+
+	for i, object := range []interface{}{validatingAdmissionPolicy, binding} {
+		raw, err := yaml.Marshal(object)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		if i != 0 {
+			fmt.Fprintln(stdout, "---")
+		}
+		fmt.Fprint(stdout, string(raw))
+	}
+	return 0
+}
+
+// readSettings reads and validates the settings file. Its warnings are
+// written to stderr.
+func readSettings(file string, stderr io.Writer) (policy.Settings, error) {
+	if file == "" {
+		return policy.Settings{}, fmt.Errorf("the -settings flag is required")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return policy.Settings{}, err
+	}
+	raw, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return policy.Settings{}, fmt.Errorf("%s: %w", file, err)
+	}
+
+	settings, err := policy.NewSettingsFromValidateSettingsPayload(raw)
+	if err != nil {
+		return policy.Settings{}, fmt.Errorf("%s: settings are not valid: %w", file, err)
+	}
+	if valid, err := settings.Valid(); !valid {
+		return policy.Settings{}, fmt.Errorf("%s: settings are not valid: %w", file, err)
+	}
+	for _, warning := range settings.Warnings() {
+		fmt.Fprintf(stderr, "%s: warning: %s\n", file, warning)
+	}
+	return settings, nil
+}
+
+// input is a manifest read by lint.
+type input struct {
+	// The file name, `-` for the standard input
+	name string
+	data []byte
+}
+
+// readManifests reads the manifests of the given files and directories, the
+// directories are walked recursively for YAML and JSON files. The standard
+// input is read when no path is given, or for `-`.
+func readManifests(paths []string, stdin io.Reader) ([]input, error) {
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	inputs := []input{}
+	for _, path := range paths {
+		if path == "-" {
+			data, err := io.ReadAll(stdin)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read the standard input: %w", err)
+			}
+			inputs = append(inputs, input{name: path, data: data})
+			continue
+		}
+
+		err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return nil
+			}
+			// the files given explicitly are always read
+			extension := strings.ToLower(filepath.Ext(file))
+			if file != path && extension != ".yaml" && extension != ".yml" && extension != ".json" {
+				return nil
+			}
+
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			inputs = append(inputs, input{name: file, data: data})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inputs, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	for _, tcase := range []struct {
+		name   string
+		args   []string
+		stdin  string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			name: "directory",
+			args: []string{"-settings", "../../test_data/lint/settings.yaml", "../../test_data/lint/manifests"},
+			code: exitViolations,
+			stdout: "../../test_data/lint/manifests/batch/cleanup.yml: CronJob batch/cleanup: " +
+				"sysctl kernel.msgmax is on the forbidden list\n" +
+				"../../test_data/lint/manifests/batch/cleanup.yml: CronJob batch/cleanup: " +
+				"sysctl kernel.shm_rmid_forced is on the forbidden list\n" +
+				"../../test_data/lint/manifests/list.json: Pod default/debug: " +
+				"warning: sysctl net.ipv4.tcp_syncookies is discouraged: the nodes already enable it\n" +
+				"../../test_data/lint/manifests/list.json: DaemonSet monitoring/agent: " +
+				"sysctl net.ipv4.ip_forward is not on safe list, nor is in the allowedUnsafeSysctls list\n",
+			stderr: "3 violations found\n",
+		},
+		{
+			name: "standard input in another namespace",
+			args: []string{"-settings", "../../test_data/lint/settings.yaml", "-namespace", "kube-system"},
+			stdin: `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: router
+spec:
+  securityContext:
+    sysctls:
+      - name: net.ipv4.ip_forward
+        value: "1"
+`,
+			code: 0,
+		},
+		{
+			name:   "malformed sysctls",
+			args:   []string{"-settings", "../../test_data/lint/settings.yaml", "-"},
+			stdin:  `{"kind": "Pod", "metadata": {"name": "broken"}, "spec": {"securityContext": {"sysctls": [{"value": "1"}]}}}`,
+			code:   exitViolations,
+			stdout: "-: Pod default/broken: spec.securityContext.sysctls[0].name is required\n",
+			stderr: "1 violation found\n",
+		},
+		{
+			name:   "missing settings",
+			args:   []string{"../../test_data/lint/manifests"},
+			code:   exitError,
+			stderr: "the -settings flag is required\n",
+		},
+		{
+			name:   "invalid settings",
+			args:   []string{"-settings", "../../test_data/lint/invalid-settings.yaml"},
+			code:   exitError,
+			stderr: "../../test_data/lint/invalid-settings.yaml: settings are not valid",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			stdout := bytes.Buffer{}
+			stderr := bytes.Buffer{}
+
+			code := lint(tcase.args, strings.NewReader(tcase.stdin), &stdout, &stderr)
+			if code != tcase.code {
+				t.Errorf("got exit code %d instead of %d, stderr: %s", code, tcase.code, stderr.String())
+			}
+			if stdout.String() != tcase.stdout {
+				t.Errorf("got stdout\n%s\ninstead of\n%s", stdout.String(), tcase.stdout)
+			}
+			if !strings.HasPrefix(stderr.String(), tcase.stderr) {
+				t.Errorf("got stderr %q, wanted it to start with %q", stderr.String(), tcase.stderr)
+			}
+		})
+	}
+}
+
+func TestExportValidatingAdmissionPolicy(t *testing.T) {
+	stdout := bytes.Buffer{}
+	stderr := bytes.Buffer{}
+
+	code := exportValidatingAdmissionPolicy(
+		[]string{"-settings", "../../test_data/lint/settings.yaml", "-name", "sysctls"},
+		&stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("got exit code %d, stderr: %s", code, stderr.String())
+	}
+	for _, expected := range []string{
+		"kind: ValidatingAdmissionPolicy\nmetadata:\n  name: sysctls\n",
+		"---\napiVersion: admissionregistration.k8s.io/v1\nkind: ValidatingAdmissionPolicyBinding\n",
+	} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("%q not found in\n%s", expected, stdout.String())
+		}
+	}
+	expected := "discouragedSysctls: not exported, the discouraged sysctls are accepted without warnings\n"
+	if stderr.String() != expected {
+		t.Errorf("got stderr %q instead of %q", stderr.String(), expected)
+	}
+}
@@ -0,0 +1,291 @@
+// Package gatekeeper converts the K8sPSPForbiddenSysctls constraints of the
+// OPA Gatekeeper library into settings of the sysctl-psp policy.
+//
+// The constraints and the policy don't have the same semantics: Gatekeeper
+// treats `allowedSysctls` as a strict allowlist, including the safe sysctls,
+// and lets `forbiddenSysctls` win over it. The policy always allows the safe
+// sysctls, and lets `allowedUnsafeSysctls` win over the forbidden patterns.
+// The converter compensates for these differences where it can, and reports
+// them otherwise.
+package gatekeeper
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/kubewarden/go-policy-template/internal/convert"
+	"github.com/kubewarden/go-policy-template/manifest"
+	"github.com/kubewarden/go-policy-template/policy"
+)
+
+// Kind is the kind of the constraints
+const Kind = "K8sPSPForbiddenSysctls"
+
+// Constraint is the subset of the K8sPSPForbiddenSysctls constraint read by
+// the converter.
+type Constraint struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		EnforcementAction string                     `json:"enforcementAction"`
+		Match             map[string]json.RawMessage `json:"match"`
+		Parameters        struct {
+			ForbiddenSysctls []string `json:"forbiddenSysctls"`
+			// nil when not set, Gatekeeper allows all the sysctls then
+			AllowedSysctls []string `json:"allowedSysctls"`
+		} `json:"parameters"`
+	} `json:"spec"`
+}
+
+// Rule is an entry of the rules list of the policy.
+type Rule struct {
+	Description string    `json:"description,omitempty"`
+	Match       RuleMatch `json:"match"`
+	Action      string    `json:"action"`
+}
+
+// RuleMatch selects the sysctls, and optionally the Pods, a Rule applies to.
+type RuleMatch struct {
+	Sysctl   string                `json:"sysctl"`
+	Selector *policy.LabelSelector `json:"selector,omitempty"`
+}
+
+// MarshalJSON writes the matches without a selector as plain strings, like
+// the policy does.
+func (m RuleMatch) MarshalJSON() ([]byte, error) {
+	if m.Selector == nil {
+		return json.Marshal(m.Sysctl)
+	}
+
+	type ruleMatchAlias RuleMatch
+	return json.Marshal(ruleMatchAlias(m))
+}
+
+// Settings are the sysctl-psp policy settings produced by the converter.
+// The rules are used instead of the allowedUnsafeSysctls and
+// forbiddenSysctls lists when the constraint has a labelSelector.
+type Settings struct {
+	AllowedUnsafeSysctls []string `json:"allowedUnsafeSysctls,omitempty"`
+	ForbiddenSysctls     []string `json:"forbiddenSysctls,omitempty"`
+	Rules                []Rule   `json:"rules,omitempty"`
+	ExemptNamespaces     []string `json:"exemptNamespaces,omitempty"`
+	EnforcementMode      string   `json:"enforcementMode,omitempty"`
+}
+
+// Result is the conversion of a constraint.
+type Result struct {
+	// The name of the constraint
+	Name     string
+	Settings Settings
+	// The differences between the constraint and the settings
+	Untranslated []string
+}
+
+// Convert reads the K8sPSPForbiddenSysctls constraints, either YAML or JSON,
+// found in data and converts them. data can hold multiple YAML documents
+// and lists. The objects of other kinds are ignored.
+func Convert(data []byte) ([]Result, error) {
+	objects, err := manifest.DecodeKind(data, Kind)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("no %s found", Kind)
+	}
+
+	results := []Result{}
+	for _, object := range objects {
+		constraint := Constraint{}
+		if err := json.Unmarshal(object, &constraint); err != nil {
+			return nil, err
+		}
+		result, err := ConvertConstraint(&constraint)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", Kind, constraint.Metadata.Name, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// ConvertConstraint converts a single constraint. An error is returned when
+// its parameters or its match block are not well formed.
+func ConvertConstraint(constraint *Constraint) (Result, error) {
+	result := Result{
+		Name:         constraint.Metadata.Name,
+		Untranslated: []string{},
+	}
+	report := func(format string, args ...interface{}) {
+		result.Untranslated = append(result.Untranslated, fmt.Sprintf(format, args...))
+	}
+	parameters := constraint.Spec.Parameters
+	safe := policy.CreateSafeSysctlsSet()
+	safeSysctls := safe.ToSlice()
+	sort.Strings(safeSysctls)
+
+	for _, sysctl := range append(append([]string{}, parameters.ForbiddenSysctls...), parameters.AllowedSysctls...) {
+		if sysctl == "" {
+			return Result{}, fmt.Errorf("parameters cannot have empty sysctls")
+		}
+		if strings.Contains(sysctl, "*") && !strings.HasSuffix(sysctl, "*") {
+			return Result{}, fmt.Errorf("parameters entry %s: `*` can only be used as suffix", sysctl)
+		}
+	}
+	forbidden := append([]string{}, parameters.ForbiddenSysctls...)
+	allowed := []string{}
+
+	if parameters.AllowedSysctls == nil || slices.Contains(parameters.AllowedSysctls, "*") {
+		report("parameters.allowedSysctls: all the sysctls are allowed by Gatekeeper, " +
+			"the policy only allows the safe sysctls and the allowedUnsafeSysctls")
+	} else {
+		for _, sysctl := range parameters.AllowedSysctls {
+			if _, found := convert.ForbiddenBy(sysctl, forbidden); found || safe.Contains(sysctl) ||
+				slices.Contains(allowed, sysctl) {
+				// Gatekeeper rejects the forbidden sysctls even when they
+				// are allowed, the safe ones are allowed by the policy
+				continue
+			}
+			if strings.HasSuffix(sysctl, "*") {
+				report("parameters.allowedSysctls entry %s: patterns are only supported for the safe sysctls, "+
+					"list the sysctls explicitly", sysctl)
+				continue
+			}
+			allowed = append(allowed, sysctl)
+		}
+
+		// allowedSysctls is a strict allowlist, the safe sysctls not on it
+		// are forbidden explicitly
+		for _, sysctl := range safeSysctls {
+			if _, found := convert.ForbiddenBy(sysctl, parameters.AllowedSysctls); found {
+				continue
+			}
+			if _, found := convert.ForbiddenBy(sysctl, forbidden); !found {
+				forbidden = append(forbidden, sysctl)
+			}
+		}
+	}
+	forbidden = convert.WithoutRedundant(forbidden)
+
+	switch constraint.Spec.EnforcementAction {
+	case "", "deny":
+	case "warn":
+		result.Settings.EnforcementMode = "warn"
+	default:
+		report("spec.enforcementAction %s: not translated, the violations are rejected",
+			constraint.Spec.EnforcementAction)
+	}
+
+	var selector *policy.LabelSelector
+	fields := []string{}
+	for field := range constraint.Spec.Match {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		raw := constraint.Spec.Match[field]
+		switch field {
+		case "excludedNamespaces":
+			if err := json.Unmarshal(raw, &result.Settings.ExemptNamespaces); err != nil {
+				return Result{}, fmt.Errorf("spec.match.excludedNamespaces must be a list of strings: %w", err)
+			}
+		case "labelSelector":
+			selector = &policy.LabelSelector{}
+			if err := json.Unmarshal(raw, selector); err != nil {
+				return Result{}, fmt.Errorf("spec.match.labelSelector: %w", err)
+			}
+		case "namespaces", "namespaceSelector":
+			report("spec.match.%s: not translated, set the namespaceSelector of the ClusterAdmissionPolicy", field)
+		case "kinds":
+			kinds := []struct {
+				Kinds []string `json:"kinds"`
+			}{}
+			if err := json.Unmarshal(raw, &kinds); err != nil {
+				return Result{}, fmt.Errorf("spec.match.kinds: %w", err)
+			}
+			pods := false
+			for _, kind := range kinds {
+				pods = pods || slices.Contains(kind.Kinds, "Pod") || slices.Contains(kind.Kinds, "*")
+			}
+			if !pods {
+				report("spec.match.kinds: not translated, the policy evaluates the Pods")
+			}
+		default:
+			report("spec.match.%s: not translated", field)
+		}
+	}
+
+	if selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0) {
+		result.Settings.AllowedUnsafeSysctls = allowed
+		result.Settings.ForbiddenSysctls = forbidden
+		return result, nil
+	}
+
+	// the forbiddenSysctls and allowedUnsafeSysctls lists cannot be scoped,
+	// the rules list is used instead: the Pods outside of the labelSelector
+	// can use all the sysctls, the others are evaluated like the lists do
+	negated, err := negate(selector)
+	if err != nil {
+		return Result{}, fmt.Errorf("spec.match.labelSelector: %w", err)
+	}
+	for _, requirement := range negated {
+		result.Settings.Rules = append(result.Settings.Rules, Rule{
+			Description: fmt.Sprintf("outside of the labelSelector of %s %s", Kind, result.Name),
+			Match: RuleMatch{
+				Sysctl:   "*",
+				Selector: &policy.LabelSelector{MatchExpressions: []policy.LabelSelectorRequirement{requirement}},
+			},
+			Action: "allow",
+		})
+	}
+	for _, sysctl := range forbidden {
+		result.Settings.Rules = append(result.Settings.Rules, Rule{Match: RuleMatch{Sysctl: sysctl}, Action: "deny"})
+	}
+	for _, sysctl := range allowed {
+		result.Settings.Rules = append(result.Settings.Rules, Rule{Match: RuleMatch{Sysctl: sysctl}, Action: "allow"})
+	}
+
+	return result, nil
+}
+
+// negate returns the requirements matching the labels not matched by the
+// selector: the labels not matched by the selector are matched by at least
+// one of the requirements.
+func negate(selector *policy.LabelSelector) ([]policy.LabelSelectorRequirement, error) {
+	negated := []policy.LabelSelectorRequirement{}
+
+	keys := []string{}
+	for key := range selector.MatchLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		negated = append(negated, policy.LabelSelectorRequirement{
+			Key:      key,
+			Operator: "NotIn",
+			Values:   []string{selector.MatchLabels[key]},
+		})
+	}
+
+	for _, requirement := range selector.MatchExpressions {
+		operator := map[string]string{
+			"In":           "NotIn",
+			"NotIn":        "In",
+			"Exists":       "DoesNotExist",
+			"DoesNotExist": "Exists",
+		}[requirement.Operator]
+		if operator == "" {
+			return nil, fmt.Errorf("%q is not a valid label selector operator", requirement.Operator)
+		}
+		negated = append(negated, policy.LabelSelectorRequirement{
+			Key:      requirement.Key,
+			Operator: operator,
+			Values:   requirement.Values,
+		})
+	}
+
+	return negated, nil
+}
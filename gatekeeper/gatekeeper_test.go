@@ -0,0 +1,241 @@
+package gatekeeper
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/kubewarden/go-policy-template/policy"
+)
+
+func TestConvert(t *testing.T) {
+	for _, tcase := range []struct {
+		fixture string
+		results []Result
+	}{
+		{
+			fixture: "../test_data/gatekeeper/constraints.yaml",
+			results: []Result{
+				{
+					Name: "psp-forbidden-sysctls",
+					Settings: Settings{
+						AllowedUnsafeSysctls: []string{},
+						ForbiddenSysctls:     []string{"kernel.*"},
+						ExemptNamespaces:     []string{"kube-system", "cilium-*"},
+					},
+					Untranslated: []string{
+						"parameters.allowedSysctls: all the sysctls are allowed by Gatekeeper, " +
+							"the policy only allows the safe sysctls and the allowedUnsafeSysctls",
+					},
+				},
+				{
+					Name: "strict-allowlist",
+					Settings: Settings{
+						AllowedUnsafeSysctls: []string{"net.core.somaxconn"},
+						ForbiddenSysctls: []string{
+							"net.ipv4.ip_*",
+							"net.ipv4.ping_group_range",
+							"net.ipv4.tcp_syncookies",
+						},
+						EnforcementMode: "warn",
+					},
+					Untranslated: []string{
+						"parameters.allowedSysctls entry kernel.msg*: patterns are only supported for the safe sysctls, " +
+							"list the sysctls explicitly",
+						"spec.match.namespaces: not translated, set the namespaceSelector of the ClusterAdmissionPolicy",
+					},
+				},
+				{
+					Name: "labelled",
+					Settings: Settings{
+						Rules: []Rule{
+							{
+								Description: "outside of the labelSelector of K8sPSPForbiddenSysctls labelled",
+								Match: RuleMatch{
+									Sysctl: "*",
+									Selector: &policy.LabelSelector{MatchExpressions: []policy.LabelSelectorRequirement{
+										{Key: "sysctls", Operator: "NotIn", Values: []string{"restricted"}},
+									}},
+								},
+								Action: "allow",
+							},
+							{
+								Description: "outside of the labelSelector of K8sPSPForbiddenSysctls labelled",
+								Match: RuleMatch{
+									Sysctl: "*",
+									Selector: &policy.LabelSelector{MatchExpressions: []policy.LabelSelectorRequirement{
+										{Key: "tier", Operator: "NotIn", Values: []string{"frontend"}},
+									}},
+								},
+								Action: "allow",
+							},
+							{Match: RuleMatch{Sysctl: "net.*"}, Action: "deny"},
+							{Match: RuleMatch{Sysctl: "kernel.msgmax"}, Action: "allow"},
+						},
+					},
+					Untranslated: []string{
+						"spec.enforcementAction dryrun: not translated, the violations are rejected",
+					},
+				},
+			},
+		},
+		{
+			fixture: "../test_data/gatekeeper/list.json",
+			results: []Result{
+				{
+					Name: "deployments-only",
+					Settings: Settings{
+						AllowedUnsafeSysctls: []string{},
+						// nothing is allowed, the safe sysctls not already
+						// forbidden are forbidden explicitly
+						ForbiddenSysctls: []string{"net.*", "kernel.shm_rmid_forced"},
+					},
+					Untranslated: []string{
+						"spec.match.kinds: not translated, the policy evaluates the Pods",
+						"spec.match.scope: not translated",
+					},
+				},
+			},
+		},
+	} {
+		t.Run(tcase.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(tcase.fixture)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			results, err := Convert(data)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if !reflect.DeepEqual(results, tcase.results) {
+				t.Errorf("got %+v instead of %+v", results, tcase.results)
+			}
+		})
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	for _, tcase := range []struct {
+		name  string
+		data  string
+		error string
+	}{
+		{
+			name: "no constraints",
+			data: `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: default
+`,
+			error: "no K8sPSPForbiddenSysctls found",
+		},
+		{
+			name: "empty sysctl",
+			data: `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sPSPForbiddenSysctls
+metadata:
+  name: broken
+spec:
+  parameters:
+    forbiddenSysctls: [""]
+`,
+			error: "K8sPSPForbiddenSysctls broken: parameters cannot have empty sysctls",
+		},
+		{
+			name: "star not used as suffix",
+			data: `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sPSPForbiddenSysctls
+metadata:
+  name: broken
+spec:
+  parameters:
+    allowedSysctls: ["net.*.somaxconn"]
+`,
+			error: "K8sPSPForbiddenSysctls broken: parameters entry net.*.somaxconn: `*` can only be used as suffix",
+		},
+		{
+			name: "unknown label selector operator",
+			data: `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sPSPForbiddenSysctls
+metadata:
+  name: broken
+spec:
+  match:
+    labelSelector:
+      matchExpressions:
+        - key: tier
+          operator: Gt
+          values: ["1"]
+  parameters:
+    forbiddenSysctls: ["*"]
+`,
+			error: "K8sPSPForbiddenSysctls broken: spec.match.labelSelector: \"Gt\" is not a valid label selector operator",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			_, err := Convert([]byte(tcase.data))
+			if err == nil || err.Error() != tcase.error {
+				t.Errorf("got error '%v', wanted '%s'", err, tcase.error)
+			}
+		})
+	}
+}
+
+func TestNegate(t *testing.T) {
+	selector := policy.LabelSelector{
+		MatchLabels: map[string]string{"b": "2", "a": "1"},
+		MatchExpressions: []policy.LabelSelectorRequirement{
+			{Key: "c", Operator: "NotIn", Values: []string{"3"}},
+			{Key: "d", Operator: "Exists"},
+			{Key: "e", Operator: "DoesNotExist"},
+		},
+	}
+	expected := []policy.LabelSelectorRequirement{
+		{Key: "a", Operator: "NotIn", Values: []string{"1"}},
+		{Key: "b", Operator: "NotIn", Values: []string{"2"}},
+		{Key: "c", Operator: "In", Values: []string{"3"}},
+		{Key: "d", Operator: "DoesNotExist"},
+		{Key: "e", Operator: "Exists"},
+	}
+
+	negated, err := negate(&selector)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if !reflect.DeepEqual(negated, expected) {
+		t.Errorf("got %+v instead of %+v", negated, expected)
+	}
+}
+
+func TestRuleMatchMarshal(t *testing.T) {
+	for _, tcase := range []struct {
+		match    RuleMatch
+		expected string
+	}{
+		{
+			match:    RuleMatch{Sysctl: "net.*"},
+			expected: `"net.*"`,
+		},
+		{
+			match: RuleMatch{
+				Sysctl:   "*",
+				Selector: &policy.LabelSelector{MatchLabels: map[string]string{"app": "nginx"}},
+			},
+			expected: `{"sysctl":"*","selector":{"matchLabels":{"app":"nginx"}}}`,
+		},
+	} {
+		raw, err := json.Marshal(tcase.match)
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if string(raw) != tcase.expected {
+			t.Errorf("got %s instead of %s", raw, tcase.expected)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Package convert holds the helpers shared by the converters of the objects
+// derived from the PodSecurityPolicy into settings of the sysctl-psp policy.
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/kubewarden/go-policy-template/policy"
+)
+
+// Settings are the sysctl-psp policy settings produced from the
+// allowedUnsafeSysctls and forbiddenSysctls fields.
+type Settings struct {
+	AllowedUnsafeSysctls []string `json:"allowedUnsafeSysctls"`
+	ForbiddenSysctls     []string `json:"forbiddenSysctls"`
+}
+
+// Sysctls converts the allowedUnsafeSysctls and forbiddenSysctls fields,
+// which have the same meaning in all the objects derived from the
+// PodSecurityPolicy. The entries that could not be translated are returned
+// too.
+func Sysctls(allowed, forbidden []string) (Settings, []string, error) {
+	settings := Settings{
+		AllowedUnsafeSysctls: []string{},
+		ForbiddenSysctls:     []string{},
+	}
+	untranslated := []string{}
+
+	for _, sysctl := range forbidden {
+		if sysctl == "" {
+			return Settings{}, nil, fmt.Errorf("forbiddenSysctls entries cannot be empty")
+		}
+		if strings.Contains(sysctl, "*") && !strings.HasSuffix(sysctl, "*") {
+			return Settings{}, nil, fmt.Errorf("forbiddenSysctls entry %s: `*` can only be used as suffix", sysctl)
+		}
+		settings.ForbiddenSysctls = append(settings.ForbiddenSysctls, sysctl)
+	}
+
+	for _, sysctl := range allowed {
+		if sysctl == "" {
+			return Settings{}, nil, fmt.Errorf("allowedUnsafeSysctls entries cannot be empty")
+		}
+		if strings.Contains(sysctl, "*") {
+			// the policy only accepts sysctl names
+			untranslated = append(untranslated,
+				fmt.Sprintf("allowedUnsafeSysctls entry %s: patterns are not supported, list the sysctls explicitly",
+					sysctl))
+			continue
+		}
+		settings.AllowedUnsafeSysctls = append(settings.AllowedUnsafeSysctls, sysctl)
+	}
+
+	return settings, untranslated, nil
+}
+
+// ForbiddenBy returns the entry of forbidden, either a name or a pattern,
+// that forbids the sysctl.
+func ForbiddenBy(sysctl string, forbidden []string) (string, bool) {
+	for _, elem := range forbidden {
+		if elem == sysctl ||
+			(strings.HasSuffix(elem, "*") && strings.HasPrefix(sysctl, strings.TrimSuffix(elem, "*"))) {
+			return elem, true
+		}
+	}
+	return "", false
+}
+
+// WithoutRedundant removes the duplicated forbiddenSysctls entries, and the
+// ones covered by a broader pattern.
+func WithoutRedundant(forbidden []string) []string {
+	kept := []string{}
+	for i, elem := range forbidden {
+		redundant := false
+		for j, other := range forbidden {
+			if other == elem && j < i {
+				redundant = true
+			}
+			if other != elem && strings.HasSuffix(other, "*") &&
+				strings.HasPrefix(strings.TrimSuffix(elem, "*"), strings.TrimSuffix(other, "*")) {
+				redundant = true
+			}
+		}
+		if !redundant {
+			kept = append(kept, elem)
+		}
+	}
+	return kept
+}
+
+// Validate parses the converted settings like the policy does, and returns
+// why they are not valid.
+func Validate(settings interface{}) error {
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	parsed, err := policy.NewSettingsFromValidateSettingsPayload(raw)
+	if err != nil {
+		return fmt.Errorf("settings are not valid: %w", err)
+	}
+	if valid, err := parsed.Valid(); !valid {
+		return fmt.Errorf("settings are not valid: %w", err)
+	}
+	return nil
+}
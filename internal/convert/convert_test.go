@@ -0,0 +1,48 @@
+package convert
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		settings interface{}
+		error    string
+	}{
+		{
+			name: "allowed sysctl covered by a forbidden pattern",
+			settings: Settings{
+				AllowedUnsafeSysctls: []string{"net.core.somaxconn"},
+				ForbiddenSysctls:     []string{"net.*"},
+			},
+		},
+		{
+			name: "sysctl both allowed and forbidden",
+			settings: Settings{
+				AllowedUnsafeSysctls: []string{"net.core.somaxconn"},
+				ForbiddenSysctls:     []string{"net.core.somaxconn"},
+			},
+			error: "settings are not valid: " +
+				"these sysctls cannot be allowed and forbidden at the same time: net.core.somaxconn",
+		},
+		{
+			name: "settings the policy cannot parse",
+			settings: map[string]interface{}{
+				"forbiddenSysctls": []int{1},
+			},
+			error: "settings are not valid: forbiddenSysctls entries must be either a string or an object: " +
+				"json: cannot unmarshal number into Go value of type policy.forbiddenSysctlAlias",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			err := Validate(tcase.settings)
+			if tcase.error == "" && err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if tcase.error != "" && (err == nil || err.Error() != tcase.error) {
+				t.Errorf("got error '%v', wanted '%s'", err, tcase.error)
+			}
+		})
+	}
+}
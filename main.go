@@ -1,22 +1,14 @@
 package main
 
 import (
-	onelog "github.com/francoispqt/onelog"
-	kubewarden "github.com/kubewarden/policy-sdk-go"
 	wapc "github.com/wapc/wapc-guest-tinygo"
-)
 
-var (
-	logWriter = kubewarden.KubewardenLogWriter{}
-	logger    = onelog.New(
-		&logWriter,
-		onelog.ALL, // shortcut for onelog.DEBUG|onelog.INFO|onelog.WARN|onelog.ERROR|onelog.FATAL
-	)
+	"github.com/kubewarden/go-policy-template/policy"
 )
 
 func main() {
 	wapc.RegisterFunctions(wapc.Functions{
-		"validate":          validate,
-		"validate_settings": validateSettings,
+		"validate":          policy.Validate,
+		"validate_settings": policy.ValidateSettings,
 	})
 }
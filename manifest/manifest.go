@@ -0,0 +1,111 @@
+// Package manifest decodes the Kubernetes objects of YAML and JSON
+// manifests, like the ones applied with kubectl.
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Object is a Kubernetes object found in a manifest.
+type Object struct {
+	// The kind of the object, implied by the typed list for its items
+	Kind string
+	// The object, as JSON
+	Raw json.RawMessage
+}
+
+// Decode returns the objects found in data, in order. data can hold multiple
+// YAML documents. The items of `List` objects and of typed lists, like
+// `DeploymentList`, are returned instead of the lists. The items of the
+// typed lists can omit the kind.
+func Decode(data []byte) ([]Object, error) {
+	objects := []Object{}
+	for index, document := range splitDocuments(data) {
+		object := struct {
+			Kind  string            `json:"kind"`
+			Items []json.RawMessage `json:"items"`
+		}{}
+		if err := yaml.Unmarshal(document, &object); err != nil {
+			return nil, fmt.Errorf("document %d: %w", index, err)
+		}
+
+		if object.Kind == "List" || (strings.HasSuffix(object.Kind, "List") && object.Items != nil) {
+			for _, item := range object.Items {
+				itemKind := struct {
+					Kind string `json:"kind"`
+				}{}
+				if err := json.Unmarshal(item, &itemKind); err != nil {
+					return nil, fmt.Errorf("document %d: %w", index, err)
+				}
+				if itemKind.Kind == "" {
+					// the kind of the items of a typed list is implied by
+					// the list
+					itemKind.Kind = strings.TrimSuffix(object.Kind, "List")
+				}
+				if itemKind.Kind != "" {
+					objects = append(objects, Object{Kind: itemKind.Kind, Raw: item})
+				}
+			}
+			continue
+		}
+		if object.Kind == "" {
+			// not a Kubernetes object, like a document holding only comments
+			continue
+		}
+
+		raw, err := yaml.YAMLToJSON(document)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", index, err)
+		}
+		objects = append(objects, Object{Kind: object.Kind, Raw: raw})
+	}
+	return objects, nil
+}
+
+// DecodeKind returns the objects of the given kind found in data, as JSON.
+// The objects of other kinds are ignored.
+func DecodeKind(data []byte, kind string) ([]json.RawMessage, error) {
+	objects, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []json.RawMessage{}
+	for _, object := range objects {
+		if object.Kind == kind {
+			found = append(found, object.Raw)
+		}
+	}
+	return found, nil
+}
+
+// splitDocuments splits a YAML stream into its documents, the empty ones are
+// skipped.
+func splitDocuments(data []byte) [][]byte {
+	documents := [][]byte{}
+	current := [][]byte{}
+	flush := func() {
+		document := bytes.Join(current, []byte("\n"))
+		if len(bytes.TrimSpace(document)) != 0 {
+			documents = append(documents, document)
+		}
+		current = [][]byte{}
+	}
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		trimmed := bytes.TrimRight(line, " \r")
+		if bytes.Equal(trimmed, []byte("---")) || bytes.HasPrefix(trimmed, []byte("--- ")) {
+			flush()
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+
+	return documents
+}
@@ -0,0 +1,101 @@
+package manifest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	for _, tcase := range []struct {
+		name  string
+		data  string
+		kinds []string
+		names []string
+	}{
+		{
+			name: "multiple documents",
+			data: `
+# the web deployment
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+---
+# nothing here
+--- 
+apiVersion: v1
+kind: Pod
+metadata:
+  name: debug
+`,
+			kinds: []string{"Deployment", "Pod"},
+			names: []string{"web", "debug"},
+		},
+		{
+			name: "list",
+			data: `
+apiVersion: v1
+kind: List
+items:
+  - apiVersion: v1
+    kind: Pod
+    metadata:
+      name: first
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: second
+`,
+			kinds: []string{"Pod", "Service"},
+			names: []string{"first", "second"},
+		},
+		{
+			name:  "typed list",
+			data:  `{"kind": "PodList", "items": [{"metadata": {"name": "first"}}, {"metadata": {"name": "second"}}]}`,
+			kinds: []string{"Pod", "Pod"},
+			names: []string{"first", "second"},
+		},
+		{
+			name:  "kind ending with List",
+			data:  `{"kind": "AllowList", "metadata": {"name": "sysctls"}}`,
+			kinds: []string{"AllowList"},
+			names: []string{"sysctls"},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			objects, err := Decode([]byte(tcase.data))
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			kinds := []string{}
+			names := []string{}
+			for _, object := range objects {
+				kinds = append(kinds, object.Kind)
+				decoded := struct {
+					Metadata struct {
+						Name string `json:"name"`
+					} `json:"metadata"`
+				}{}
+				if err := json.Unmarshal(object.Raw, &decoded); err != nil {
+					t.Fatalf("unexpected error %+v", err)
+				}
+				names = append(names, decoded.Metadata.Name)
+			}
+			if !reflect.DeepEqual(kinds, tcase.kinds) {
+				t.Errorf("got kinds %v instead of %v", kinds, tcase.kinds)
+			}
+			if !reflect.DeepEqual(names, tcase.names) {
+				t.Errorf("got names %v instead of %v", names, tcase.names)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, err := Decode([]byte("kind: Pod\n---\nkind: [Pod\n"))
+	if err == nil {
+		t.Errorf("got no error for a malformed document")
+	}
+}
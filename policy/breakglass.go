@@ -0,0 +1,78 @@
+package policy
+
+import (
+	"fmt"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	metav1 "github.com/kubewarden/k8s-objects/apimachinery/pkg/apis/meta/v1"
+	"github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+const (
+	// BreakGlassAnnotation holds the justification, usually a ticket
+	// reference, for bypassing forbiddenSysctls
+	BreakGlassAnnotation = "sysctl-psp.kubewarden.io/break-glass"
+	// BreakGlassExpiresAnnotation holds the RFC3339 timestamp after which
+	// the break-glass annotation is no longer honored
+	BreakGlassExpiresAnnotation = "sysctl-psp.kubewarden.io/break-glass-expires"
+)
+
+// now returns the current time, it's a variable to allow tests to change it.
+var now = time.Now
+
+// BreakGlass configures the escape hatch that allows to bypass
+// forbiddenSysctls during incidents.
+type BreakGlass struct {
+	Enabled bool `json:"enabled"`
+	// The groups of the users that are allowed to break glass
+	Groups []string `json:"groups,omitempty"`
+}
+
+// breakGlassJustification returns the justification of the break-glass
+// annotation when the forbiddenSysctls can be bypassed, an empty string
+// otherwise.
+//
+// The annotation is only taken into account when break-glass is enabled and
+// the user making the request belongs to one of the configured groups. In
+// that case, an error is returned when the annotations are malformed or
+// expired.
+func breakGlassJustification(
+	settings *Settings,
+	request *protocol.KubernetesAdmissionRequest,
+	metadata *metav1.ObjectMeta,
+) (string, error) {
+	if !settings.BreakGlass.Enabled {
+		return "", nil
+	}
+
+	justification, found := metadata.Annotations[BreakGlassAnnotation]
+	if !found {
+		return "", nil
+	}
+
+	groups := mapset.NewThreadUnsafeSet(settings.BreakGlass.Groups...)
+	if !groups.ContainsAny(request.UserInfo.Groups...) {
+		return "", nil
+	}
+
+	if justification == "" {
+		return "", fmt.Errorf("annotation %s cannot be empty", BreakGlassAnnotation)
+	}
+
+	expires, found := metadata.Annotations[BreakGlassExpiresAnnotation]
+	if !found {
+		return "", fmt.Errorf("annotation %s requires the %s annotation",
+			BreakGlassAnnotation, BreakGlassExpiresAnnotation)
+	}
+	expiration, err := time.Parse(time.RFC3339, expires)
+	if err != nil {
+		return "", fmt.Errorf("annotation %s must be a RFC3339 timestamp: %w",
+			BreakGlassExpiresAnnotation, err)
+	}
+	if !now().Before(expiration) {
+		return "", fmt.Errorf("break-glass %s expired at %s", justification, expires)
+	}
+
+	return justification, nil
+}
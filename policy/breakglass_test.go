@@ -0,0 +1,212 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+func TestBreakGlass(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	}
+	defer func() { now = time.Now }()
+
+	enabled := BreakGlass{Enabled: true, Groups: []string{"sre"}}
+
+	for _, tcase := range []struct {
+		name       string
+		breakGlass BreakGlass
+		// The forbiddenSysctls, net.core.somaxconn when not set. The
+		// sysctl is in allowedUnsafeSysctls too
+		forbidden   []interface{}
+		sysctl      string
+		groups      []string
+		annotations map[string]string
+		accepted    bool
+		message     string
+	}{
+		{
+			name:       "bypass forbidden sysctl",
+			breakGlass: enabled,
+			groups:     []string{"system:authenticated", "sre"},
+			annotations: map[string]string{
+				BreakGlassAnnotation:        "INC-1234",
+				BreakGlassExpiresAnnotation: "2024-03-01T12:00:00Z",
+			},
+			accepted: true,
+		},
+		{
+			name:       "break-glass disabled",
+			breakGlass: BreakGlass{Groups: []string{"sre"}},
+			groups:     []string{"sre"},
+			annotations: map[string]string{
+				BreakGlassAnnotation:        "INC-1234",
+				BreakGlassExpiresAnnotation: "2024-03-01T12:00:00Z",
+			},
+			accepted: false,
+			message:  "sysctl net.core.somaxconn is on the forbidden list",
+		},
+		{
+			name:       "requester not in the break-glass groups",
+			breakGlass: enabled,
+			groups:     []string{"developers"},
+			annotations: map[string]string{
+				BreakGlassAnnotation:        "INC-1234",
+				BreakGlassExpiresAnnotation: "2024-03-01T12:00:00Z",
+			},
+			accepted: false,
+			message:  "sysctl net.core.somaxconn is on the forbidden list",
+		},
+		{
+			name:       "expired",
+			breakGlass: enabled,
+			groups:     []string{"sre"},
+			annotations: map[string]string{
+				BreakGlassAnnotation:        "INC-1234",
+				BreakGlassExpiresAnnotation: "2024-03-01T09:00:00Z",
+			},
+			accepted: false,
+			message:  "break-glass INC-1234 expired at 2024-03-01T09:00:00Z",
+		},
+		{
+			name:       "missing expiration",
+			breakGlass: enabled,
+			groups:     []string{"sre"},
+			annotations: map[string]string{
+				BreakGlassAnnotation: "INC-1234",
+			},
+			accepted: false,
+			message: "annotation sysctl-psp.kubewarden.io/break-glass requires the " +
+				"sysctl-psp.kubewarden.io/break-glass-expires annotation",
+		},
+		{
+			name:       "malformed expiration",
+			breakGlass: enabled,
+			groups:     []string{"sre"},
+			annotations: map[string]string{
+				BreakGlassAnnotation:        "INC-1234",
+				BreakGlassExpiresAnnotation: "tomorrow",
+			},
+			accepted: false,
+			message: "annotation sysctl-psp.kubewarden.io/break-glass-expires must be a RFC3339 timestamp: " +
+				`parsing time "tomorrow" as "2006-01-02T15:04:05Z07:00": cannot parse "tomorrow" as "2006"`,
+		},
+		{
+			name:       "empty justification",
+			breakGlass: enabled,
+			groups:     []string{"sre"},
+			annotations: map[string]string{
+				BreakGlassAnnotation:        "",
+				BreakGlassExpiresAnnotation: "2024-03-01T12:00:00Z",
+			},
+			accepted: false,
+			message:  "annotation sysctl-psp.kubewarden.io/break-glass cannot be empty",
+		},
+		{
+			name:       "bypass forbidden safe sysctl",
+			breakGlass: enabled,
+			forbidden:  []interface{}{"kernel.*"},
+			sysctl:     "kernel.shm_rmid_forced",
+			groups:     []string{"sre"},
+			annotations: map[string]string{
+				BreakGlassAnnotation:        "INC-1234",
+				BreakGlassExpiresAnnotation: "2024-03-01T12:00:00Z",
+			},
+			accepted: true,
+		},
+		{
+			name:       "bypassed pattern, sysctl not allowed",
+			breakGlass: enabled,
+			forbidden:  []interface{}{"kernel.*"},
+			sysctl:     "kernel.sem",
+			groups:     []string{"sre"},
+			annotations: map[string]string{
+				BreakGlassAnnotation:        "INC-1234",
+				BreakGlassExpiresAnnotation: "2024-03-01T12:00:00Z",
+			},
+			accepted: false,
+			message:  "sysctl kernel.sem is not on safe list, nor is in the allowedUnsafeSysctls list",
+		},
+		{
+			name:       "bypassed warn entry, sysctl not allowed",
+			breakGlass: enabled,
+			forbidden:  []interface{}{map[string]string{"name": "kernel.sem", "action": "warn"}},
+			sysctl:     "kernel.sem",
+			groups:     []string{"sre"},
+			annotations: map[string]string{
+				BreakGlassAnnotation:        "INC-1234",
+				BreakGlassExpiresAnnotation: "2024-03-01T12:00:00Z",
+			},
+			accepted: false,
+			message:  "sysctl kernel.sem is not on safe list, nor is in the allowedUnsafeSysctls list",
+		},
+		{
+			name:       "bypassed log pattern, sysctl not allowed",
+			breakGlass: enabled,
+			forbidden:  []interface{}{map[string]string{"name": "kernel.*", "action": "log"}},
+			sysctl:     "kernel.sem",
+			groups:     []string{"sre"},
+			annotations: map[string]string{
+				BreakGlassAnnotation:        "INC-1234",
+				BreakGlassExpiresAnnotation: "2024-03-01T12:00:00Z",
+			},
+			accepted: false,
+			message:  "sysctl kernel.sem is not on safe list, nor is in the allowedUnsafeSysctls list",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			forbidden, sysctl := tcase.forbidden, tcase.sysctl
+			if forbidden == nil {
+				forbidden = []interface{}{"net.core.somaxconn"}
+			}
+			if sysctl == "" {
+				sysctl = "net.core.somaxconn"
+			}
+			settingsRaw, _ := json.Marshal(map[string]interface{}{
+				"allowedUnsafeSysctls": []string{"net.core.somaxconn"},
+				"forbiddenSysctls":     forbidden,
+				"breakGlass":           tcase.breakGlass,
+			})
+
+			annotations, _ := json.Marshal(tcase.annotations)
+			object := json.RawMessage(`{
+				"kind": "Pod",
+				"apiVersion": "v1",
+				"metadata": {"name": "incident", "namespace": "default", "annotations": ` + string(annotations) + `},
+				"spec": {
+					"containers": [{"name": "hello", "image": "busybox"}],
+					"securityContext": {"sysctls": [{"name": "` + sysctl + `", "value": "1"}]}
+				}
+			}`)
+			payload, _ := json.Marshal(kubewarden_protocol.ValidationRequest{
+				Request: kubewarden_protocol.KubernetesAdmissionRequest{
+					Kind:      kubewarden_protocol.GroupVersionKind{Version: "v1", Kind: "Pod"},
+					Operation: "CREATE",
+					UserInfo:  kubewarden_protocol.UserInfo{Username: "alice", Groups: tcase.groups},
+					Object:    object,
+				},
+				Settings: settingsRaw,
+			})
+
+			responsePayload, err := Validate(payload)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			if response.Accepted != tcase.accepted {
+				t.Fatalf("got accepted %v, wanted %v", response.Accepted, tcase.accepted)
+			}
+			if !tcase.accepted && *response.Message != tcase.message {
+				t.Errorf("got '%s' instead of '%s'", *response.Message, tcase.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "github.com/kubewarden/k8s-objects/apimachinery/pkg/apis/meta/v1"
+)
+
+// Exception allows the matching Pods to use a sysctl until a given date.
+type Exception struct {
+	RuleMetadata
+	// The sysctl name or pattern
+	Sysctl string `json:"sysctl"`
+	// The namespaces where the exception applies, all of them when empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Selects the Pods, by label, the exception applies to
+	Selector *LabelSelector `json:"selector,omitempty"`
+	// Either a RFC3339 timestamp, or a date (YYYY-MM-DD). A date means the
+	// exception is valid until the end of that day, UTC
+	ValidUntil string `json:"validUntil"`
+	// Why the exception has been granted
+	Reason string `json:"reason"`
+}
+
+// expiration returns the time at which the exception stops applying.
+func (e *Exception) expiration() (time.Time, error) {
+	if expiration, err := time.Parse(time.RFC3339, e.ValidUntil); err == nil {
+		return expiration, nil
+	}
+
+	day, err := time.Parse(time.DateOnly, e.ValidUntil)
+	if err != nil {
+		return time.Time{},
+			fmt.Errorf("validUntil must be either a date (YYYY-MM-DD) or a RFC3339 timestamp")
+	}
+	return day.Add(24 * time.Hour), nil
+}
+
+// expired returns true when the exception no longer applies.
+func (e *Exception) expired() bool {
+	expiration, err := e.expiration()
+	return err != nil || !now().Before(expiration)
+}
+
+// Valid returns an error when the exception is not well formed.
+func (e *Exception) Valid() error {
+	if e.Sysctl == "" {
+		return fmt.Errorf("sysctl cannot be empty")
+	}
+	if strings.Contains(e.Sysctl, "*") && !isSysctlPattern(e.Sysctl) {
+		return fmt.Errorf("sysctl only accepts patterns with `*` as suffix")
+	}
+	if e.Reason == "" {
+		return fmt.Errorf("reason cannot be empty")
+	}
+	if _, err := e.expiration(); err != nil {
+		return err
+	}
+	if e.Selector != nil {
+		if err := e.Selector.Valid(); err != nil {
+			return fmt.Errorf("selector: %w", err)
+		}
+	}
+	return nil
+}
+
+// Matches returns true when the exception allows the given sysctl to be used
+// by the object with the given metadata.
+func (e *Exception) Matches(sysctl string, metadata *metav1.ObjectMeta) bool {
+	if e.Sysctl != sysctl && !(isSysctlPattern(e.Sysctl) && matchesSysctlPattern(e.Sysctl, sysctl)) {
+		return false
+	}
+
+	if len(e.Namespaces) != 0 {
+		found := false
+		for _, namespace := range e.Namespaces {
+			found = found || namespace == metadata.Namespace
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if e.Selector != nil && !e.Selector.Matches(metadata.Labels) {
+		return false
+	}
+
+	return !e.expired()
+}
+
+// exceptionFor returns the exception allowing the given sysctl to be used by
+// the object with the given metadata, nil when there's none.
+func (s *Settings) exceptionFor(sysctl string, metadata *metav1.ObjectMeta) *Exception {
+	for i := range s.Exceptions {
+		if s.Exceptions[i].Matches(sysctl, metadata) {
+			return &s.Exceptions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestExceptions(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	}
+	defer func() { now = time.Now }()
+
+	for _, tcase := range []struct {
+		name      string
+		exception Exception
+		accepted  bool
+	}{
+		{
+			name: "matching exception",
+			exception: Exception{
+				Sysctl:     "net.core.somaxconn",
+				Namespaces: []string{"default"},
+				Selector:   &LabelSelector{MatchLabels: map[string]string{"env": "test"}},
+				ValidUntil: "2024-03-01",
+				Reason:     "migration of the legacy load balancer",
+			},
+			accepted: true,
+		},
+		{
+			name: "matching pattern",
+			exception: Exception{
+				Sysctl:     "net.core.*",
+				ValidUntil: "2024-03-01T12:00:00Z",
+				Reason:     "migration of the legacy load balancer",
+			},
+			accepted: true,
+		},
+		{
+			name: "expired exception",
+			exception: Exception{
+				Sysctl:     "net.core.somaxconn",
+				ValidUntil: "2024-02-29",
+				Reason:     "migration of the legacy load balancer",
+			},
+			accepted: false,
+		},
+		{
+			name: "other namespace",
+			exception: Exception{
+				Sysctl:     "net.core.somaxconn",
+				Namespaces: []string{"ingress"},
+				ValidUntil: "2024-03-01",
+				Reason:     "migration of the legacy load balancer",
+			},
+			accepted: false,
+		},
+		{
+			name: "labels not matching",
+			exception: Exception{
+				Sysctl:     "net.core.somaxconn",
+				Selector:   &LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+				ValidUntil: "2024-03-01",
+				Reason:     "migration of the legacy load balancer",
+			},
+			accepted: false,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			settings := Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("net.*"),
+				Exceptions:           []Exception{tcase.exception},
+			}
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				"../test_data/request-pod-somaxconn.json",
+				&settings)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			responsePayload, err := Validate(payload)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			if response.Accepted != tcase.accepted {
+				t.Errorf("got accepted %v, wanted %v", response.Accepted, tcase.accepted)
+			}
+		})
+	}
+}
+
+func TestExceptionsSettings(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	}
+	defer func() { now = time.Now }()
+
+	for _, tcase := range []struct {
+		name     string
+		settings string
+		error    string
+		warnings []string
+	}{
+		{
+			name: "valid exception",
+			settings: `{"exceptions": [
+				{"sysctl": "net.core.somaxconn", "validUntil": "2024-06-30", "reason": "legacy load balancer"}
+			]}`,
+			warnings: []string{},
+		},
+		{
+			name: "expired exception",
+			settings: `{"exceptions": [
+				{"sysctl": "net.core.somaxconn", "validUntil": "2024-06-30", "reason": "legacy load balancer"},
+				{"sysctl": "kernel.msgmax", "validUntil": "2024-01-31", "reason": "database migration"}
+			]}`,
+			warnings: []string{"exceptions[1] for kernel.msgmax expired on 2024-01-31 and can be removed"},
+		},
+		{
+			name:     "malformed validUntil",
+			settings: `{"exceptions": [{"sysctl": "net.core.somaxconn", "validUntil": "next week", "reason": "legacy"}]}`,
+			error:    "exceptions[0]: validUntil must be either a date (YYYY-MM-DD) or a RFC3339 timestamp",
+		},
+		{
+			name:     "missing reason",
+			settings: `{"exceptions": [{"sysctl": "net.core.somaxconn", "validUntil": "2024-06-30"}]}`,
+			error:    "exceptions[0]: reason cannot be empty",
+		},
+		{
+			name: "invalid selector",
+			settings: `{"exceptions": [{
+				"sysctl": "net.core.somaxconn",
+				"validUntil": "2024-06-30",
+				"reason": "legacy",
+				"selector": {"matchExpressions": [{"key": "env", "operator": "In"}]}
+			}]}`,
+			error: "exceptions[0]: selector: matchExpressions key env: values must be non-empty when operator is In",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(tcase.settings))
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			valid, err := settings.Valid()
+			if tcase.error != "" {
+				if valid || err == nil || err.Error() != tcase.error {
+					t.Errorf("got error '%v', wanted '%s'", err, tcase.error)
+				}
+				return
+			}
+			if !valid {
+				t.Fatalf("got unexpected error '%v'", err)
+			}
+
+			warnings := settings.Warnings()
+			if len(warnings) != len(tcase.warnings) {
+				t.Fatalf("got warnings %v, wanted %v", warnings, tcase.warnings)
+			}
+			for i := range warnings {
+				if warnings[i] != tcase.warnings[i] {
+					t.Errorf("got warning '%s', wanted '%s'", warnings[i], tcase.warnings[i])
+				}
+			}
+		})
+	}
+}
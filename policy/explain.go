@@ -0,0 +1,195 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	metav1 "github.com/kubewarden/k8s-objects/apimachinery/pkg/apis/meta/v1"
+)
+
+// ExplainAnnotation enables the explain mode for a single Pod when set to
+// `true`
+const ExplainAnnotation = "sysctl-psp.kubewarden.io/explain"
+
+// explainEnabled returns true when the decision trace must be reported for
+// the given object.
+func explainEnabled(settings *Settings, metadata *metav1.ObjectMeta) bool {
+	return settings.Explain || metadata.Annotations[ExplainAnnotation] == "true"
+}
+
+// sysctlTrace describes how the settings have been applied to a sysctl.
+type sysctlTrace struct {
+	sysctl string
+	value  string
+	// The outcome of each check
+	safe      string
+	allowed   string
+	forbidden string
+	// The rules matching the sysctl, when the rules list is used
+	rules string
+	// The rule that decides the outcome, by precedence
+	winner string
+	// The final decision, including the exceptions and break-glass
+	decision string
+}
+
+// decide records the final decision about the sysctl. It can be called on a
+// nil trace, when the explain mode is not enabled.
+func (t *sysctlTrace) decide(format string, args ...any) {
+	if t == nil {
+		return
+	}
+	t.decision = fmt.Sprintf(format, args...)
+}
+
+func (t *sysctlTrace) String() string {
+	if t.rules != "" {
+		return fmt.Sprintf("%s=%s: safe list: %s; rules: %s; winner: %s; decision: %s",
+			t.sysctl, t.value, t.safe, t.rules, t.winner, t.decision)
+	}
+	return fmt.Sprintf("%s=%s: safe list: %s; allowedUnsafeSysctls: %s; forbiddenSysctls: %s; winner: %s; decision: %s",
+		t.sysctl, t.value, t.safe, t.allowed, t.forbidden, t.winner, t.decision)
+}
+
+// decisionTrace lists the evaluation of the sysctls of a Pod.
+type decisionTrace []*sysctlTrace
+
+func (d decisionTrace) String() string {
+	lines := []string{"decision trace:"}
+	for _, t := range d {
+		lines = append(lines, "- "+t.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// warnings returns the decision trace as admission warnings, one for each
+// sysctl.
+func (d decisionTrace) warnings() []string {
+	warnings := []string{}
+	for _, t := range d {
+		warnings = append(warnings, "decision trace: "+t.String())
+	}
+	return warnings
+}
+
+// explainSysctl returns the trace of the checks done against the given
+// sysctl. It follows the precedence applied by validateSysctl:
+//
+// - an exact forbiddenSysctls entry wins over everything else
+// - allowedUnsafeSysctls wins over the forbiddenSysctls patterns
+// - the most specific forbiddenSysctls pattern wins over the safe list
+func explainSysctl(settings *Settings, entry *corev1.Sysctl, w *workload) *sysctlTrace {
+	sysctl := *entry.Name
+	t := &sysctlTrace{
+		sysctl:    sysctl,
+		value:     sysctlValue(entry),
+		safe:      "no",
+		allowed:   "not listed",
+		forbidden: "no match",
+		winner:    "none, the sysctl is neither on the safe list nor allowed",
+	}
+
+	safe := CreateSafeSysctlsSet().Contains(sysctl)
+	if safe {
+		t.safe = "yes"
+	}
+
+	if len(settings.Rules) != 0 {
+		explainRules(settings, t, w, safe)
+		return t
+	}
+
+	allowingRule, allowed := settings.allowingRule(sysctl, w)
+	if settings.allowedUnsafeSysctlsSet().Contains(sysctl) {
+		switch {
+		case !settings.allowedUnsafeSysctlScoped(sysctl):
+			t.allowed = "listed" + allowingRule.reference()
+		case allowed:
+			t.allowed = "listed, a scoped entry matches the workload" + allowingRule.reference()
+		default:
+			t.allowed = "listed, no scoped entry matches the workload"
+		}
+	}
+
+	patterns := []string{}
+	pattern := ""
+	for _, elem := range settings.forbiddenSysctlsSet().ToSlice() {
+		if isSysctlPattern(elem) && matchesSysctlPattern(elem, sysctl) {
+			patterns = append(patterns, elem)
+			if len(elem) > len(pattern) {
+				pattern = elem
+			}
+		}
+	}
+	sort.Strings(patterns)
+	exact := settings.forbiddenSysctlsSet().Contains(sysctl)
+	switch {
+	case exact:
+		t.forbidden = "exact entry " + sysctl
+		if len(patterns) != 0 {
+			t.forbidden += ", patterns " + strings.Join(patterns, ", ")
+		}
+	case len(patterns) != 0:
+		t.forbidden = fmt.Sprintf("patterns %s, the most specific is %s",
+			strings.Join(patterns, ", "), pattern)
+	}
+
+	switch {
+	case exact:
+		t.winner = fmt.Sprintf("forbiddenSysctls entry %s %s",
+			sysctl, forbiddenRuleDetails(settings.forbiddenSysctlRule(sysctl)))
+	case allowed && pattern != "":
+		t.winner = "allowedUnsafeSysctls" + allowingRule.reference() +
+			", it has precedence over the forbiddenSysctls patterns"
+	case allowed:
+		t.winner = "allowedUnsafeSysctls" + allowingRule.reference()
+	case pattern != "":
+		t.winner = fmt.Sprintf("forbiddenSysctls pattern %s %s",
+			pattern, forbiddenRuleDetails(settings.forbiddenSysctlRule(pattern)))
+	case safe:
+		t.winner = "safe list"
+	}
+
+	return t
+}
+
+// explainRules completes the trace of a sysctl evaluated with the rules
+// list. The first matching rule wins.
+func explainRules(settings *Settings, t *sysctlTrace, w *workload, safe bool) {
+	matching := []string{}
+	winner := -1
+	for index, rule := range settings.Rules {
+		if rule.Match.matches(t.sysctl, w) {
+			matching = append(matching, fmt.Sprintf("rules[%d] %s", index, rule.Match.Sysctl))
+			if winner < 0 {
+				winner = index
+			}
+		}
+	}
+
+	t.rules = "no match"
+	if len(matching) != 0 {
+		t.rules = strings.Join(matching, ", ")
+	}
+	switch {
+	case winner >= 0:
+		rule := settings.Rules[winner]
+		t.winner = fmt.Sprintf("rules[%d]%s, the first matching rule (action %s)",
+			winner, rule.reference(), rule.Action)
+	case safe:
+		t.winner = "safe list"
+	default:
+		t.winner = "none, the sysctl is neither on the safe list nor matched by the rules"
+	}
+}
+
+// forbiddenRuleDetails returns the id and the action of the given rule, as
+// reported by the decision trace.
+func forbiddenRuleDetails(rule ForbiddenSysctl) string {
+	if rule.ID != "" {
+		return fmt.Sprintf("(rule %s, action %s)", rule.ID, rule.action())
+	}
+	return fmt.Sprintf("(action %s)", rule.action())
+}
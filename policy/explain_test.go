@@ -0,0 +1,190 @@
+package policy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	metav1 "github.com/kubewarden/k8s-objects/apimachinery/pkg/apis/meta/v1"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestExplainSysctl(t *testing.T) {
+	settings := Settings{
+		AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet("net.core.somaxconn", "kernel.msgmax"),
+		AllowedUnsafeSysctlRules: map[string][]AllowedUnsafeSysctl{
+			"kernel.msgmax": {{Name: "kernel.msgmax", ServiceAccounts: []string{"ipc/worker"}}},
+		},
+		ForbiddenSysctls: mapset.NewThreadUnsafeSet("net.*", "net.core.*", "kernel.sem"),
+	}
+	w := workload{
+		metadata: &metav1.ObjectMeta{Namespace: "default"},
+		podSpec:  &corev1.PodSpec{},
+	}
+
+	for _, tcase := range []struct {
+		sysctl string
+		trace  string
+	}{
+		{
+			sysctl: "kernel.shm_rmid_forced",
+			trace: "kernel.shm_rmid_forced=1: safe list: yes; allowedUnsafeSysctls: not listed; " +
+				"forbiddenSysctls: no match; winner: safe list; decision: ",
+		},
+		{
+			sysctl: "net.core.somaxconn",
+			trace: "net.core.somaxconn=1: safe list: no; allowedUnsafeSysctls: listed; " +
+				"forbiddenSysctls: patterns net.*, net.core.*, the most specific is net.core.*; " +
+				"winner: allowedUnsafeSysctls, it has precedence over the forbiddenSysctls patterns; decision: ",
+		},
+		{
+			sysctl: "net.ipv4.ip_local_port_range",
+			trace: "net.ipv4.ip_local_port_range=1: safe list: yes; allowedUnsafeSysctls: not listed; " +
+				"forbiddenSysctls: patterns net.*, the most specific is net.*; " +
+				"winner: forbiddenSysctls pattern net.* (action deny); decision: ",
+		},
+		{
+			sysctl: "kernel.sem",
+			trace: "kernel.sem=1: safe list: no; allowedUnsafeSysctls: not listed; " +
+				"forbiddenSysctls: exact entry kernel.sem; winner: forbiddenSysctls entry kernel.sem (action deny); decision: ",
+		},
+		{
+			sysctl: "kernel.msgmax",
+			trace: "kernel.msgmax=1: safe list: no; allowedUnsafeSysctls: listed, no scoped entry matches the workload; " +
+				"forbiddenSysctls: no match; winner: none, the sysctl is neither on the safe list nor allowed; decision: ",
+		},
+	} {
+		t.Run(tcase.sysctl, func(t *testing.T) {
+			value := "1"
+			trace := explainSysctl(&settings, &corev1.Sysctl{Name: &tcase.sysctl, Value: &value}, &w)
+			if trace.String() != tcase.trace {
+				t.Errorf("got '%s' instead of '%s'", trace.String(), tcase.trace)
+			}
+		})
+	}
+}
+
+func TestExplainMode(t *testing.T) {
+	for _, tcase := range []struct {
+		name        string
+		annotations map[string]string
+		explain     bool
+		forbidden   []string
+		accepted    bool
+		message     string
+		warnings    []string
+	}{
+		{
+			name:     "disabled",
+			accepted: true,
+		},
+		{
+			name:     "enabled by the settings",
+			explain:  true,
+			accepted: true,
+			warnings: []string{
+				"decision trace: kernel.shm_rmid_forced=1: safe list: yes; allowedUnsafeSysctls: not listed; " +
+					"forbiddenSysctls: no match; winner: safe list; decision: accepted",
+			},
+		},
+		{
+			name:        "enabled by the annotation",
+			annotations: map[string]string{ExplainAnnotation: "true"},
+			forbidden:   []string{"kernel.*"},
+			message: "sysctl kernel.shm_rmid_forced is on the forbidden list\n" +
+				"decision trace:\n" +
+				"- kernel.shm_rmid_forced=1: safe list: yes; allowedUnsafeSysctls: not listed; " +
+				"forbiddenSysctls: patterns kernel.*, the most specific is kernel.*; " +
+				"winner: forbiddenSysctls pattern kernel.* (action deny); " +
+				"decision: denied: sysctl kernel.shm_rmid_forced is on the forbidden list",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			name := "kernel.shm_rmid_forced"
+			value := "1"
+			pod := corev1.Pod{
+				Metadata: &metav1.ObjectMeta{
+					Name:        "test",
+					Namespace:   "default",
+					Annotations: tcase.annotations,
+				},
+				Spec: &corev1.PodSpec{
+					SecurityContext: &corev1.PodSecurityContext{
+						Sysctls: []*corev1.Sysctl{{Name: &name, Value: &value}},
+					},
+				},
+			}
+			settings := Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet(tcase.forbidden...),
+				Explain:              tcase.explain,
+			}
+			object, err := json.Marshal(&pod)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+			payload, err := buildPodValidationRequest(object, &settings)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			responsePayload, err := Validate(payload)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			var response validationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			if response.Accepted != tcase.accepted {
+				t.Fatalf("got accepted %v, wanted %v", response.Accepted, tcase.accepted)
+			}
+			message := ""
+			if response.Message != nil {
+				message = *response.Message
+			}
+			if message != tcase.message {
+				t.Errorf("got '%s' instead of '%s'", message, tcase.message)
+			}
+			if !reflect.DeepEqual(response.Warnings, tcase.warnings) {
+				t.Errorf("got warnings %q instead of %q", response.Warnings, tcase.warnings)
+			}
+		})
+	}
+}
+
+func TestExplainExemptedNamespace(t *testing.T) {
+	settings := Settings{
+		AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+		ForbiddenSysctls:     mapset.NewThreadUnsafeSet[string](),
+		ExemptNamespaces:     []string{"default"},
+		Explain:              true,
+	}
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+		"../test_data/request-pod-somaxconn.json",
+		&settings)
+	if err != nil {
+		t.Fatalf("unexpected error '%+v'", err)
+	}
+
+	responsePayload, err := Validate(payload)
+	if err != nil {
+		t.Fatalf("unexpected error '%+v'", err)
+	}
+
+	var response validationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Fatalf("unexpected error '%+v'", err)
+	}
+	if !response.Accepted {
+		t.Fatalf("got unexpected rejection")
+	}
+	expected := []string{"decision trace: namespace default is exempted"}
+	if !reflect.DeepEqual(response.Warnings, expected) {
+		t.Errorf("got warnings %q instead of %q", response.Warnings, expected)
+	}
+}
@@ -0,0 +1,8 @@
+package policy
+
+// The helpers used by the external tests, the ones importing the packages
+// that import the policy.
+var (
+	RoundTrip                 = roundTrip
+	BuildPodValidationRequest = buildPodValidationRequest
+)
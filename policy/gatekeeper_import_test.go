@@ -0,0 +1,156 @@
+package policy_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	metav1 "github.com/kubewarden/k8s-objects/apimachinery/pkg/apis/meta/v1"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+
+	"github.com/kubewarden/go-policy-template/gatekeeper"
+	"github.com/kubewarden/go-policy-template/policy"
+)
+
+func TestConvertedGatekeeperConstraintsRoundTrip(t *testing.T) {
+	for _, fixture := range []string{
+		"../test_data/gatekeeper/constraints.yaml",
+		"../test_data/gatekeeper/list.json",
+	} {
+		t.Run(fixture, func(t *testing.T) {
+			data, err := os.ReadFile(fixture)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			results, err := gatekeeper.Convert(data)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			for _, result := range results {
+				policy.RoundTrip(t, result.Settings)
+			}
+		})
+	}
+}
+
+// admittedByGatekeeper evaluates the sysctl like the K8sPSPForbiddenSysctls
+// template of the Gatekeeper library does.
+func admittedByGatekeeper(t *testing.T, constraint *gatekeeper.Constraint, labels map[string]string, sysctl string) bool {
+	if raw, found := constraint.Spec.Match["labelSelector"]; found {
+		selector := policy.LabelSelector{}
+		if err := json.Unmarshal(raw, &selector); err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if !selector.Matches(labels) {
+			return true
+		}
+	}
+
+	matches := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if pattern == sysctl || pattern == "*" ||
+				(strings.HasSuffix(pattern, "*") && strings.HasPrefix(sysctl, strings.TrimSuffix(pattern, "*"))) {
+				return true
+			}
+		}
+		return false
+	}
+	parameters := constraint.Spec.Parameters
+	if matches(parameters.ForbiddenSysctls) {
+		return false
+	}
+	return parameters.AllowedSysctls == nil || matches(parameters.AllowedSysctls)
+}
+
+func TestConvertedGatekeeperConstraintsDecisions(t *testing.T) {
+	sysctls := append(policy.CreateSafeSysctlsSet().ToSlice(), "net.core.somaxconn", "net.ipv4.ip_forward", "kernel.msgmax")
+	labels := []map[string]string{
+		{},
+		{"sysctls": "restricted"},
+		{"sysctls": "restricted", "tier": "frontend"},
+		{"sysctls": "other", "tier": "frontend", "team": "a"},
+		{"tier": "backend", "team": "b"},
+	}
+
+	// only the constraints translated without differences
+	for _, constraint := range []string{
+		`{"parameters": {
+			"forbiddenSysctls": ["net.ipv4.ip_*"],
+			"allowedSysctls": ["net.core.somaxconn", "net.ipv4.ip_local_port_range", "kernel.shm_rmid_forced"]
+		}}`,
+		`{"parameters": {"forbiddenSysctls": ["net.*"], "allowedSysctls": []}}`,
+		`{"parameters": {"allowedSysctls": ["net.ipv4.ip_local_port_range", "kernel.msgmax"]}}`,
+		`{"parameters": {"forbiddenSysctls": ["*"], "allowedSysctls": ["kernel.msgmax"]}}`,
+		`{
+			"match": {"labelSelector": {
+				"matchLabels": {"sysctls": "restricted"},
+				"matchExpressions": [{"key": "team", "operator": "DoesNotExist"}]
+			}},
+			"parameters": {"forbiddenSysctls": ["net.ipv4.ip_*"], "allowedSysctls": ["net.core.somaxconn"]}
+		}`,
+		`{
+			"match": {"labelSelector": {"matchExpressions": [
+				{"key": "tier", "operator": "NotIn", "values": ["backend"]},
+				{"key": "team", "operator": "Exists"}
+			]}},
+			"parameters": {"forbiddenSysctls": ["kernel.*"], "allowedSysctls": ["kernel.msgmax", "net.core.somaxconn"]}
+		}`,
+	} {
+		parsed := gatekeeper.Constraint{}
+		if err := json.Unmarshal([]byte(fmt.Sprintf(`{"metadata": {"name": "test"}, "spec": %s}`, constraint)), &parsed); err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		result, err := gatekeeper.ConvertConstraint(&parsed)
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if len(result.Untranslated) != 0 {
+			t.Fatalf("%s: unexpected differences %q", constraint, result.Untranslated)
+		}
+		settings := policy.RoundTrip(t, result.Settings)
+
+		for _, podLabels := range labels {
+			for _, sysctl := range sysctls {
+				t.Run(fmt.Sprintf("%s %v %s", constraint, podLabels, sysctl), func(t *testing.T) {
+					value := "1"
+					pod := corev1.Pod{
+						Metadata: &metav1.ObjectMeta{
+							Name:      "test",
+							Namespace: "default",
+							Labels:    podLabels,
+						},
+						Spec: &corev1.PodSpec{
+							SecurityContext: &corev1.PodSecurityContext{
+								Sysctls: []*corev1.Sysctl{{Name: &sysctl, Value: &value}},
+							},
+						},
+					}
+					object, err := json.Marshal(&pod)
+					if err != nil {
+						t.Fatalf("unexpected error '%+v'", err)
+					}
+					payload, err := policy.BuildPodValidationRequest(object, &settings)
+					if err != nil {
+						t.Fatalf("unexpected error '%+v'", err)
+					}
+
+					responsePayload, err := policy.Validate(payload)
+					if err != nil {
+						t.Fatalf("unexpected error '%+v'", err)
+					}
+					var response kubewarden_protocol.ValidationResponse
+					if err := json.Unmarshal(responsePayload, &response); err != nil {
+						t.Fatalf("unexpected error '%+v'", err)
+					}
+					if admitted := admittedByGatekeeper(t, &parsed, podLabels, sysctl); admitted != response.Accepted {
+						t.Errorf("got accepted %v from the policy, admitted %v by Gatekeeper", response.Accepted, admitted)
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+)
+
+const (
+	// ImagesMatchAll requires all the containers of the Pod to match
+	ImagesMatchAll = "all"
+	// ImagesMatchAny requires at least one container of the Pod to match
+	ImagesMatchAny = "any"
+)
+
+// imageReference is a container image reference, split into its parts.
+type imageReference struct {
+	// The repository, including the registry, e.g. docker.io/library/busybox
+	name   string
+	tag    string
+	digest string
+}
+
+// parseImageReference parses the given container image reference. Images
+// without registry are normalized to Docker Hub ones, the same way the
+// container runtime does it.
+func parseImageReference(image string) imageReference {
+	ref := imageReference{}
+
+	name, digest, found := strings.Cut(image, "@")
+	if found {
+		ref.digest = digest
+	}
+
+	// the tag is after the last `:`, unless that's the port of the registry
+	if index := strings.LastIndex(name, ":"); index > strings.LastIndex(name, "/") {
+		ref.tag = name[index+1:]
+		name = name[:index]
+	}
+
+	registry, _, found := strings.Cut(name, "/")
+	switch {
+	case !found:
+		name = "docker.io/library/" + name
+	case !strings.ContainsAny(registry, ".:") && registry != "localhost":
+		name = "docker.io/" + name
+	}
+	ref.name = name
+
+	return ref
+}
+
+// validImagePattern returns an error when the image pattern is not well
+// formed.
+func validImagePattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("images entries cannot be empty")
+	}
+
+	ref := parseImageReference(pattern)
+	if _, err := path.Match(ref.name, ""); err != nil {
+		return fmt.Errorf("image pattern %s is not valid: %w", pattern, err)
+	}
+	if ref.digest != "" {
+		algorithm, hex, found := strings.Cut(ref.digest, ":")
+		if !found || algorithm == "" || hex == "" {
+			return fmt.Errorf("image pattern %s: digest must be in the form `algorithm:hex`", pattern)
+		}
+	}
+
+	return nil
+}
+
+// matchesImagePattern returns true when the image matches the pattern. The
+// repository of the pattern can contain globs, e.g. `registry.example.com/db/*`.
+// When the pattern has a tag or a digest, the image must have the same one.
+func matchesImagePattern(pattern, image string) bool {
+	patternRef := parseImageReference(pattern)
+	imageRef := parseImageReference(image)
+
+	if matched, err := path.Match(patternRef.name, imageRef.name); err != nil || !matched {
+		return false
+	}
+	if patternRef.tag != "" && patternRef.tag != imageRef.tag {
+		return false
+	}
+	if patternRef.digest != "" && patternRef.digest != imageRef.digest {
+		return false
+	}
+
+	return true
+}
+
+// podImages returns the images used by all the containers of the Pod,
+// including the init and the ephemeral ones.
+func podImages(podSpec *corev1.PodSpec) []string {
+	images := []string{}
+	for _, container := range podSpec.InitContainers {
+		if container != nil {
+			images = append(images, container.Image)
+		}
+	}
+	for _, container := range podSpec.Containers {
+		if container != nil {
+			images = append(images, container.Image)
+		}
+	}
+	for _, container := range podSpec.EphemeralContainers {
+		if container != nil {
+			images = append(images, container.Image)
+		}
+	}
+	return images
+}
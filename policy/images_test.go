@@ -0,0 +1,85 @@
+package policy
+
+import (
+	"testing"
+)
+
+func TestParseImageReference(t *testing.T) {
+	for _, tcase := range []struct {
+		image    string
+		expected imageReference
+	}{
+		{
+			image:    "busybox",
+			expected: imageReference{name: "docker.io/library/busybox"},
+		},
+		{
+			image:    "bitnami/postgresql:15",
+			expected: imageReference{name: "docker.io/bitnami/postgresql", tag: "15"},
+		},
+		{
+			image:    "registry.example.com:5000/db/postgres:15@sha256:0123abcd",
+			expected: imageReference{name: "registry.example.com:5000/db/postgres", tag: "15", digest: "sha256:0123abcd"},
+		},
+		{
+			image:    "localhost/haproxy",
+			expected: imageReference{name: "localhost/haproxy"},
+		},
+	} {
+		t.Run(tcase.image, func(t *testing.T) {
+			if ref := parseImageReference(tcase.image); ref != tcase.expected {
+				t.Errorf("got %+v, wanted %+v", ref, tcase.expected)
+			}
+		})
+	}
+}
+
+func TestMatchesImagePattern(t *testing.T) {
+	for _, tcase := range []struct {
+		pattern string
+		image   string
+		matches bool
+	}{
+		{pattern: "busybox", image: "busybox:1.36", matches: true},
+		{pattern: "docker.io/library/busybox", image: "busybox", matches: true},
+		{pattern: "registry.example.com/db/*", image: "registry.example.com/db/postgres:15", matches: true},
+		{pattern: "registry.example.com/db/*", image: "registry.example.com/lb/haproxy:2.9", matches: false},
+		{pattern: "registry.example.com/db/*", image: "registry.example.com/db/team/postgres", matches: false},
+		{pattern: "registry.example.com/*/*", image: "registry.example.com/db/postgres", matches: true},
+		{pattern: "postgres:15", image: "postgres:16", matches: false},
+		{pattern: "postgres@sha256:0123abcd", image: "postgres:15@sha256:0123abcd", matches: true},
+		{pattern: "postgres@sha256:0123abcd", image: "postgres:15", matches: false},
+	} {
+		t.Run(tcase.pattern+" "+tcase.image, func(t *testing.T) {
+			if matches := matchesImagePattern(tcase.pattern, tcase.image); matches != tcase.matches {
+				t.Errorf("got %v, wanted %v", matches, tcase.matches)
+			}
+		})
+	}
+}
+
+func TestValidImagePattern(t *testing.T) {
+	for _, tcase := range []struct {
+		pattern string
+		error   string
+	}{
+		{pattern: "registry.example.com/db/*"},
+		{pattern: "postgres@sha256:0123abcd"},
+		{pattern: "", error: "images entries cannot be empty"},
+		{pattern: "registry.example.com/db/[", error: "image pattern registry.example.com/db/[ is not valid: syntax error in pattern"},
+		{pattern: "postgres@0123abcd", error: "image pattern postgres@0123abcd: digest must be in the form `algorithm:hex`"},
+	} {
+		t.Run(tcase.pattern, func(t *testing.T) {
+			err := validImagePattern(tcase.pattern)
+			if tcase.error == "" {
+				if err != nil {
+					t.Errorf("got unexpected error '%v'", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tcase.error {
+				t.Errorf("got error '%v', wanted '%s'", err, tcase.error)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	onelog "github.com/francoispqt/onelog"
+	metav1 "github.com/kubewarden/k8s-objects/apimachinery/pkg/apis/meta/v1"
+	kubewarden "github.com/kubewarden/policy-sdk-go"
+)
+
+const (
+	// ViolationForbiddenExact is the type of the violations caused by a
+	// forbiddenSysctls entry naming the sysctl
+	ViolationForbiddenExact = "forbidden-exact"
+	// ViolationForbiddenPattern is the type of the violations caused by a
+	// forbiddenSysctls pattern matching the sysctl
+	ViolationForbiddenPattern = "forbidden-pattern"
+	// ViolationNotAllowed is the type of the violations caused by a sysctl
+	// that is neither on the safe list nor allowed
+	ViolationNotAllowed = "not-allowed"
+)
+
+const (
+	// StructuredRejectionPrefix starts the line of the rejection message
+	// holding the structured rejection
+	StructuredRejectionPrefix = "sysctl-psp-violations: "
+	// StructuredRejectionVersion is the version of the structured rejection
+	// format
+	StructuredRejectionVersion = "v1"
+)
+
+// structuredRejection lists the violations causing a rejection, in a
+// machine-readable form.
+type structuredRejection struct {
+	Version    string            `json:"version"`
+	Violations []ViolationReport `json:"violations"`
+}
+
+// ViolationReport describes a violation causing a rejection, it's an entry
+// of the structured rejection.
+type ViolationReport struct {
+	Sysctl string `json:"sysctl"`
+	Value  string `json:"value"`
+	// One of the Violation* constants
+	Type string `json:"type"`
+	// The forbiddenSysctls entry that has been matched, its index and
+	// metadata. When the rules list is used, the match and the index of the
+	// rule
+	Rule            string `json:"rule,omitempty"`
+	RuleIndex       *int   `json:"ruleIndex,omitempty"`
+	RuleID          string `json:"ruleId,omitempty"`
+	RuleDescription string `json:"ruleDescription,omitempty"`
+	Message         string `json:"message"`
+}
+
+// newStructuredRejection returns the structured rejection describing the
+// given violations.
+func newStructuredRejection(violations []*violation) structuredRejection {
+	rejection := structuredRejection{
+		Version:    StructuredRejectionVersion,
+		Violations: []ViolationReport{},
+	}
+	for _, v := range violations {
+		entry := ViolationReport{
+			Sysctl:          v.sysctl,
+			Value:           v.value,
+			Type:            v.kind,
+			Rule:            v.rule.Name,
+			RuleID:          v.rule.ID,
+			RuleDescription: v.rule.Description,
+			Message:         v.Error(),
+		}
+		if v.ruleIndex >= 0 {
+			index := v.ruleIndex
+			entry.RuleIndex = &index
+		}
+		rejection.Violations = append(rejection.Violations, entry)
+	}
+	return rejection
+}
+
+// rejectionMessageData holds the fields available to the
+// rejectionMessageTemplate.
+type rejectionMessageData struct {
+	// The sysctl causing the rejection
+	Sysctl string
+	// The value of the sysctl
+	Value string
+	// The forbiddenSysctls rule that has been matched, empty when the sysctl
+	// is neither on the safe list nor allowed
+	Rule string
+	// The id and the description of the rule, when set
+	RuleID          string
+	RuleDescription string
+	// The namespace and the name of the rejected object
+	Namespace string
+	Name      string
+	// The docsUrl from the settings
+	DocsURL string
+	// The default rejection message
+	Message string
+}
+
+// parseRejectionMessageTemplate parses the rejectionMessageTemplate of the
+// settings. It returns nil when the template is not set.
+func parseRejectionMessageTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New("rejectionMessageTemplate").Option("missingkey=error").Parse(text)
+}
+
+// rejectionMessage returns the message of the rejection caused by the given
+// violations. The message describes the first violation, followed by the
+// optional explanation. When structuredRejection is enabled all the
+// violations are appended in a machine-readable form.
+func rejectionMessage(
+	settings *Settings,
+	violations []*violation,
+	metadata *metav1.ObjectMeta,
+	explanation string,
+) string {
+	message := humanRejectionMessage(settings, violations[0], metadata)
+	if explanation != "" {
+		message += "\n" + explanation
+	}
+	if !settings.StructuredRejection {
+		return message
+	}
+
+	rejection, err := json.Marshal(newStructuredRejection(violations))
+	if err != nil {
+		logger.ErrorWithFields("cannot build the structured rejection", func(e onelog.Entry) {
+			e.String("error", err.Error())
+		})
+		return message
+	}
+	return message + "\n" + StructuredRejectionPrefix + string(rejection)
+}
+
+// humanRejectionMessage returns the message describing the given violation.
+// When the rejectionMessageTemplate cannot be rendered, the default message
+// is returned.
+func humanRejectionMessage(settings *Settings, v *violation, metadata *metav1.ObjectMeta) string {
+	tmpl, err := parseRejectionMessageTemplate(settings.RejectionMessageTemplate)
+	if tmpl == nil || err != nil {
+		return v.Error()
+	}
+
+	data := rejectionMessageData{
+		Sysctl:          v.sysctl,
+		Value:           v.value,
+		Rule:            v.rule.Name,
+		RuleID:          v.rule.ID,
+		RuleDescription: v.rule.Description,
+		Namespace:       metadata.Namespace,
+		Name:            metadata.Name,
+		DocsURL:         settings.DocsURL,
+		Message:         v.Error(),
+	}
+	message := bytes.Buffer{}
+	if err := tmpl.Execute(&message, data); err != nil {
+		logger.ErrorWithFields("cannot render rejectionMessageTemplate", func(e onelog.Entry) {
+			e.String("error", err.Error())
+		})
+		return v.Error()
+	}
+
+	return message.String()
+}
+
+// rejectionCode returns the code of the rejections caused by policy
+// violations.
+func rejectionCode(settings *Settings) kubewarden.Code {
+	if settings.RejectionCode == 0 {
+		return kubewarden.NoCode
+	}
+	return kubewarden.Code(settings.RejectionCode)
+}
+
+// validRejectionSettings returns an error when the rejectionMessageTemplate or
+// the rejectionCode of the settings are not valid.
+func validRejectionSettings(settings *Settings) error {
+	tmpl, err := parseRejectionMessageTemplate(settings.RejectionMessageTemplate)
+	if err != nil {
+		return fmt.Errorf("rejectionMessageTemplate is not valid: %w", err)
+	}
+	if tmpl != nil {
+		// catch references to unknown fields early
+		if err := tmpl.Execute(&bytes.Buffer{}, rejectionMessageData{}); err != nil {
+			return fmt.Errorf("rejectionMessageTemplate is not valid: %w", err)
+		}
+	}
+
+	if settings.RejectionCode != 0 &&
+		(settings.RejectionCode < 400 || settings.RejectionCode > 599) {
+		return fmt.Errorf("rejectionCode must be between 400 and 599")
+	}
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package policy
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestRejectionMessageTemplate(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		template string
+		code     uint16
+		message  string
+	}{
+		{
+			name:    "default message and code",
+			message: "sysctl net.core.somaxconn is on the forbidden list",
+		},
+		{
+			name: "custom message and code",
+			template: "[{{ .Namespace }}/{{ .Name }}] {{ .Sysctl }}={{ .Value }} matched {{ .Rule }}, " +
+				"see {{ .DocsURL }}",
+			code:    403,
+			message: "[default/hello-z5xq7] net.core.somaxconn=1024 matched net.*, see https://docs.example.com/sysctls",
+		},
+		{
+			name:     "default message inside the template",
+			template: "{{ .Message }} (contact #platform)",
+			message:  "sysctl net.core.somaxconn is on the forbidden list (contact #platform)",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			settings := Settings{
+				AllowedUnsafeSysctls:     mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:         mapset.NewThreadUnsafeSet("net.*"),
+				RejectionMessageTemplate: tcase.template,
+				RejectionCode:            tcase.code,
+				DocsURL:                  "https://docs.example.com/sysctls",
+			}
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				"../test_data/request-pod-somaxconn.json",
+				&settings)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			responsePayload, err := Validate(payload)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			if response.Accepted {
+				t.Fatalf("got unexpected approval")
+			}
+			if *response.Message != tcase.message {
+				t.Errorf("got '%s' instead of '%s'", *response.Message, tcase.message)
+			}
+			if tcase.code == 0 && response.Code != nil {
+				t.Errorf("got code %d, wanted none", *response.Code)
+			}
+			if tcase.code != 0 && (response.Code == nil || *response.Code != tcase.code) {
+				t.Errorf("got code %v, wanted %d", response.Code, tcase.code)
+			}
+		})
+	}
+}
+
+func TestValidRejectionSettings(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		settings Settings
+		error    string
+	}{
+		{
+			name:     "valid",
+			settings: Settings{RejectionMessageTemplate: "{{ .Sysctl }} is not allowed", RejectionCode: 403},
+		},
+		{
+			name:     "malformed template",
+			settings: Settings{RejectionMessageTemplate: "{{ .Sysctl "},
+			error: "rejectionMessageTemplate is not valid: template: rejectionMessageTemplate:1: " +
+				"unclosed action",
+		},
+		{
+			name:     "unknown field",
+			settings: Settings{RejectionMessageTemplate: "{{ .Container }}"},
+			error: "rejectionMessageTemplate is not valid: template: rejectionMessageTemplate:1:3: " +
+				"executing \"rejectionMessageTemplate\" at <.Container>: can't evaluate field Container " +
+				"in type policy.rejectionMessageData",
+		},
+		{
+			name:     "code out of range",
+			settings: Settings{RejectionCode: 200},
+			error:    "rejectionCode must be between 400 and 599",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			err := validRejectionSettings(&tcase.settings)
+			if tcase.error == "" {
+				if err != nil {
+					t.Errorf("got unexpected error '%v'", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tcase.error {
+				t.Errorf("got error '%v', wanted '%s'", err, tcase.error)
+			}
+		})
+	}
+}
+
+func TestStructuredRejection(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+
+	for _, tcase := range []struct {
+		name       string
+		fixture    string
+		settings   string
+		message    string
+		violations []ViolationReport
+	}{
+		{
+			name:    "disabled",
+			fixture: "../test_data/request-pod-somaxconn.json",
+			settings: `{
+				"forbiddenSysctls": ["net.*"]
+			}`,
+			message: "sysctl net.core.somaxconn is on the forbidden list",
+		},
+		{
+			name:    "sysctl not allowed",
+			fixture: "../test_data/request-pod-somaxconn.json",
+			settings: `{
+				"structuredRejection": true
+			}`,
+			message: "sysctl net.core.somaxconn is not on safe list, nor is in the allowedUnsafeSysctls list",
+			violations: []ViolationReport{
+				{
+					Sysctl:  "net.core.somaxconn",
+					Value:   "1024",
+					Type:    ViolationNotAllowed,
+					Message: "sysctl net.core.somaxconn is not on safe list, nor is in the allowedUnsafeSysctls list",
+				},
+			},
+		},
+		{
+			name:    "all the denied sysctls, with the index of the rules",
+			fixture: "../test_data/request-pod-safe-sysctls.json",
+			settings: `{
+				"forbiddenSysctls": [
+					"kernel.*",
+					"net.ipv4.tcp_syncookies",
+					{"name": "net.*", "message": "ask the network team", "id": "CR-7", "description": "network tuning"}
+				],
+				"structuredRejection": true
+			}`,
+			message: "sysctl kernel.shm_rmid_forced is on the forbidden list",
+			violations: []ViolationReport{
+				{
+					Sysctl:    "kernel.shm_rmid_forced",
+					Value:     "foo",
+					Type:      ViolationForbiddenPattern,
+					Rule:      "kernel.*",
+					RuleIndex: intPtr(0),
+					Message:   "sysctl kernel.shm_rmid_forced is on the forbidden list",
+				},
+				{
+					Sysctl:          "net.ipv4.ip_local_port_range",
+					Value:           "bar",
+					Type:            ViolationForbiddenPattern,
+					Rule:            "net.*",
+					RuleIndex:       intPtr(2),
+					RuleID:          "CR-7",
+					RuleDescription: "network tuning",
+					Message:         "sysctl net.ipv4.ip_local_port_range is on the forbidden list (rule CR-7): ask the network team",
+				},
+				{
+					Sysctl:    "net.ipv4.tcp_syncookies",
+					Value:     "baz",
+					Type:      ViolationForbiddenExact,
+					Rule:      "net.ipv4.tcp_syncookies",
+					RuleIndex: intPtr(1),
+					Message:   "sysctl net.ipv4.tcp_syncookies is on the forbidden list",
+				},
+				{
+					Sysctl:          "net.ipv4.ping_group_range",
+					Value:           "bal",
+					Type:            ViolationForbiddenPattern,
+					Rule:            "net.*",
+					RuleIndex:       intPtr(2),
+					RuleID:          "CR-7",
+					RuleDescription: "network tuning",
+					Message:         "sysctl net.ipv4.ping_group_range is on the forbidden list (rule CR-7): ask the network team",
+				},
+			},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				tcase.fixture,
+				json.RawMessage(tcase.settings))
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			responsePayload, err := Validate(payload)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+			if response.Accepted {
+				t.Fatalf("got unexpected approval")
+			}
+
+			message, structured, found := strings.Cut(*response.Message, "\n"+StructuredRejectionPrefix)
+			if message != tcase.message {
+				t.Errorf("got '%s' instead of '%s'", message, tcase.message)
+			}
+			if tcase.violations == nil {
+				if found {
+					t.Errorf("got unexpected structured rejection '%s'", structured)
+				}
+				return
+			}
+			if !found {
+				t.Fatalf("structured rejection not found in '%s'", *response.Message)
+			}
+
+			var rejection structuredRejection
+			if err := json.Unmarshal([]byte(structured), &rejection); err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+			expected := structuredRejection{
+				Version:    StructuredRejectionVersion,
+				Violations: tcase.violations,
+			}
+			if !reflect.DeepEqual(rejection, expected) {
+				t.Errorf("got %+v instead of %+v", rejection, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+// Package policy implements the sysctl-psp policy: its settings, and the
+// evaluation of the admission requests against them.
+//
+// The WebAssembly module of the policy registers Validate and
+// ValidateSettings as its entrypoints. The sysctl-psp command uses Evaluate
+// to lint the manifests before they reach the cluster.
+package policy
+
+import (
+	onelog "github.com/francoispqt/onelog"
+	kubewarden "github.com/kubewarden/policy-sdk-go"
+)
+
+var (
+	logWriter = kubewarden.KubewardenLogWriter{}
+	logger    = onelog.New(
+		&logWriter,
+		onelog.ALL, // shortcut for onelog.DEBUG|onelog.INFO|onelog.WARN|onelog.ERROR|onelog.FATAL
+	)
+)
+
+// SetLogger replaces the logger of the policy, by default the logs are sent
+// to the Kubewarden host.
+func SetLogger(l *onelog.Logger) {
+	logger = l
+}
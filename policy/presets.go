@@ -0,0 +1,99 @@
+package policy
+
+import (
+	"sort"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+const (
+	// PresetBaseline only allows the safe sysctls
+	PresetBaseline = "baseline"
+	// PresetStrict forbids all the sysctls, including the safe ones
+	PresetStrict = "strict"
+	// PresetNetworkTuning allows the namespaced sysctls commonly tuned by
+	// network intensive workloads
+	PresetNetworkTuning = "network-tuning"
+	// PresetIPCHeavy allows the IPC sysctls commonly tuned by databases
+	PresetIPCHeavy = "ipc-heavy"
+)
+
+// Preset is a curated set of allowedUnsafeSysctls and forbiddenSysctls,
+// selected by the `preset` setting.
+type Preset struct {
+	AllowedUnsafeSysctls []string
+	ForbiddenSysctls     []string
+}
+
+// presets are the presets shipped with the policy, keyed by name.
+var presets = map[string]Preset{
+	PresetBaseline: {},
+	PresetStrict: {
+		ForbiddenSysctls: []string{"*"},
+	},
+	PresetNetworkTuning: {
+		AllowedUnsafeSysctls: []string{
+			"net.core.somaxconn",
+			"net.ipv4.tcp_fin_timeout",
+			"net.ipv4.tcp_keepalive_intvl",
+			"net.ipv4.tcp_keepalive_probes",
+			"net.ipv4.tcp_keepalive_time",
+			"net.ipv4.tcp_max_syn_backlog",
+			"net.ipv4.tcp_rmem",
+			"net.ipv4.tcp_tw_reuse",
+			"net.ipv4.tcp_wmem",
+		},
+	},
+	PresetIPCHeavy: {
+		AllowedUnsafeSysctls: []string{
+			"kernel.msgmax",
+			"kernel.msgmnb",
+			"kernel.msgmni",
+			"kernel.sem",
+			"kernel.shmall",
+			"kernel.shmmax",
+			"kernel.shmmni",
+		},
+	},
+}
+
+// presetNames returns the names of the presets, sorted.
+func presetNames() []string {
+	names := []string{}
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// merge returns the allowedUnsafeSysctls and forbiddenSysctls resulting from
+// extending the preset with the given entries. An entry overrides the preset
+// entries with the same name, in either list.
+func (p Preset) merge(
+	allowed []AllowedUnsafeSysctl,
+	forbidden []ForbiddenSysctl,
+) ([]AllowedUnsafeSysctl, []ForbiddenSysctl) {
+	explicit := mapset.NewThreadUnsafeSet[string]()
+	for _, rule := range allowed {
+		explicit.Add(rule.Name)
+	}
+	for _, rule := range forbidden {
+		explicit.Add(rule.Name)
+	}
+
+	mergedAllowed := append([]AllowedUnsafeSysctl{}, allowed...)
+	for _, name := range p.AllowedUnsafeSysctls {
+		if !explicit.Contains(name) {
+			mergedAllowed = append(mergedAllowed, AllowedUnsafeSysctl{Name: name})
+		}
+	}
+	mergedForbidden := append([]ForbiddenSysctl{}, forbidden...)
+	for _, name := range p.ForbiddenSysctls {
+		if !explicit.Contains(name) {
+			mergedForbidden = append(mergedForbidden, ForbiddenSysctl{Name: name})
+		}
+	}
+
+	return mergedAllowed, mergedForbidden
+}
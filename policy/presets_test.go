@@ -0,0 +1,186 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestPresetsAreValid(t *testing.T) {
+	for _, name := range presetNames() {
+		t.Run(name, func(t *testing.T) {
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(`{"preset": "` + name + `"}`))
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if warnings := settings.Warnings(); len(warnings) != 0 {
+				t.Errorf("unexpected warnings %v", warnings)
+			}
+		})
+	}
+}
+
+func TestExtendingStrictPresetHasNoWarnings(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload(
+		[]byte(`{"preset": "strict", "allowedUnsafeSysctls": ["net.core.somaxconn"]}`))
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if warnings := settings.Warnings(); len(warnings) != 0 {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+}
+
+func TestPresetMerge(t *testing.T) {
+	for _, tcase := range []struct {
+		name      string
+		settings  string
+		allowed   []string
+		forbidden []string
+	}{
+		{
+			name:      "preset only",
+			settings:  `{"preset": "strict"}`,
+			allowed:   []string{},
+			forbidden: []string{"*"},
+		},
+		{
+			name: "explicit lists extend the preset",
+			settings: `{
+				"preset": "ipc-heavy",
+				"allowedUnsafeSysctls": ["net.core.somaxconn"],
+				"forbiddenSysctls": ["vm.*"]
+			}`,
+			allowed: []string{
+				"kernel.msgmax", "kernel.msgmnb", "kernel.msgmni", "kernel.sem",
+				"kernel.shmall", "kernel.shmmax", "kernel.shmmni", "net.core.somaxconn",
+			},
+			forbidden: []string{"vm.*"},
+		},
+		{
+			name: "explicit entries override the preset",
+			settings: `{
+				"preset": "ipc-heavy",
+				"forbiddenSysctls": ["kernel.sem", "kernel.shmall", "kernel.shmmax", "kernel.shmmni"]
+			}`,
+			allowed:   []string{"kernel.msgmax", "kernel.msgmnb", "kernel.msgmni"},
+			forbidden: []string{"kernel.sem", "kernel.shmall", "kernel.shmmax", "kernel.shmmni"},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(tcase.settings))
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			if !settings.AllowedUnsafeSysctls.Equal(mapset.NewThreadUnsafeSet(tcase.allowed...)) {
+				t.Errorf("got allowedUnsafeSysctls %v, wanted %v", settings.AllowedUnsafeSysctls, tcase.allowed)
+			}
+			if !settings.ForbiddenSysctls.Equal(mapset.NewThreadUnsafeSet(tcase.forbidden...)) {
+				t.Errorf("got forbiddenSysctls %v, wanted %v", settings.ForbiddenSysctls, tcase.forbidden)
+			}
+
+			// the preset is applied again when the settings are sent to
+			// the policy, the result must not change
+			raw, err := json.Marshal(settings)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			roundTrip, err := NewSettingsFromValidateSettingsPayload(raw)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if !roundTrip.AllowedUnsafeSysctls.Equal(settings.AllowedUnsafeSysctls) ||
+				!roundTrip.ForbiddenSysctls.Equal(settings.ForbiddenSysctls) {
+				t.Errorf("got %s after round trip", raw)
+			}
+		})
+	}
+}
+
+func TestPresetValidation(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		fixture  string
+		settings string
+		accepted bool
+	}{
+		{
+			name:     "baseline rejects unsafe sysctls",
+			fixture:  "../test_data/request-pod-somaxconn.json",
+			settings: `{"preset": "baseline"}`,
+		},
+		{
+			name:     "baseline accepts safe sysctls",
+			fixture:  "../test_data/request-pod-safe-sysctls.json",
+			settings: `{"preset": "baseline"}`,
+			accepted: true,
+		},
+		{
+			name:     "strict rejects safe sysctls",
+			fixture:  "../test_data/request-pod-safe-sysctls.json",
+			settings: `{"preset": "strict"}`,
+		},
+		{
+			name:     "strict extended by allowedUnsafeSysctls",
+			fixture:  "../test_data/request-pod-somaxconn.json",
+			settings: `{"preset": "strict", "allowedUnsafeSysctls": ["net.core.somaxconn"]}`,
+			accepted: true,
+		},
+		{
+			name:     "network-tuning",
+			fixture:  "../test_data/request-pod-somaxconn.json",
+			settings: `{"preset": "network-tuning"}`,
+			accepted: true,
+		},
+		{
+			name:     "network-tuning overridden by forbiddenSysctls",
+			fixture:  "../test_data/request-pod-somaxconn.json",
+			settings: `{"preset": "network-tuning", "forbiddenSysctls": ["net.core.somaxconn"]}`,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				tcase.fixture,
+				json.RawMessage(tcase.settings))
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			responsePayload, err := Validate(payload)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+			if response.Accepted != tcase.accepted {
+				t.Errorf("got accepted %v, wanted %v", response.Accepted, tcase.accepted)
+			}
+		})
+	}
+}
+
+func TestUnknownPreset(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`{"preset": "relaxed"}`))
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	valid, err := settings.Valid()
+	expected := "preset must be one of `baseline`, `ipc-heavy`, `network-tuning`, `strict`"
+	if valid || err == nil || err.Error() != expected {
+		t.Errorf("got error '%v', wanted '%s'", err, expected)
+	}
+}
@@ -0,0 +1,34 @@
+package policy_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/kubewarden/go-policy-template/policy"
+	"github.com/kubewarden/go-policy-template/psp"
+)
+
+// The settings produced by the converter must be valid, and a round trip
+// through the policy must not change them.
+func TestConvertedPodSecurityPoliciesAreValid(t *testing.T) {
+	for _, fixture := range []string{
+		"../test_data/psp/restricted.yaml",
+		"../test_data/psp/sysctls.yaml",
+		"../test_data/psp/list.json",
+	} {
+		t.Run(fixture, func(t *testing.T) {
+			data, err := os.ReadFile(fixture)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			results, err := psp.Convert(data)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			for _, result := range results {
+				policy.RoundTrip(t, result.Settings)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package policy
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+// roundTrip parses the converted settings and checks they are valid, that
+// all the converted sysctls are kept and that writing and parsing them again
+// doesn't change them.
+func roundTrip(t *testing.T, converted interface{}) Settings {
+	parse := func(raw []byte) Settings {
+		settings, err := NewSettingsFromValidateSettingsPayload(raw)
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if valid, err := settings.Valid(); !valid {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		return settings
+	}
+
+	raw, err := json.Marshal(converted)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	settings := parse(raw)
+
+	lists := struct {
+		AllowedUnsafeSysctls []AllowedUnsafeSysctl `json:"allowedUnsafeSysctls"`
+		ForbiddenSysctls     []string              `json:"forbiddenSysctls"`
+	}{}
+	if err := json.Unmarshal(raw, &lists); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	allowed := mapset.NewThreadUnsafeSet[string]()
+	for _, rule := range lists.AllowedUnsafeSysctls {
+		allowed.Add(rule.Name)
+	}
+	if !settings.AllowedUnsafeSysctls.Equal(allowed) {
+		t.Errorf("got allowedUnsafeSysctls %v, wanted %v", settings.AllowedUnsafeSysctls, allowed)
+	}
+	forbidden := []string{}
+	for _, rule := range settings.forbiddenSysctlsList() {
+		forbidden = append(forbidden, rule.Name)
+	}
+	if !slices.Equal(forbidden, lists.ForbiddenSysctls) {
+		t.Errorf("got forbiddenSysctls %v, wanted %v", forbidden, lists.ForbiddenSysctls)
+	}
+
+	written, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	rewritten, err := json.Marshal(parse(written))
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if string(written) != string(rewritten) {
+		t.Errorf("got %s after round trip of %s", rewritten, written)
+	}
+	return settings
+}
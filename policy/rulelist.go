@@ -0,0 +1,333 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+)
+
+// ActionAllow accepts the request, it can only be used by the rules list
+const ActionAllow = "allow"
+
+// Rule is an entry of the rules list. The rules are evaluated top to bottom,
+// the first one matching the sysctl decides:
+//
+//	{
+//	   "match": "net.*",
+//	   "action": "deny",
+//	   "message": "...",
+//	   "id": "CR-1234",
+//	   "description": "..."
+//	}
+type Rule struct {
+	RuleMetadata
+	Match RuleMatch `json:"match"`
+	// Either `allow`, `deny`, `warn` or `log`
+	Action string `json:"action"`
+	// Optional message shown to the user when the rule is matched
+	Message string `json:"message,omitempty"`
+}
+
+// RuleMatch selects the sysctls, and optionally the Pods, a Rule applies to.
+// It can be provided either as a plain string, the sysctl name or pattern,
+// or as an object:
+//
+//	{
+//	   "sysctl": "net.core.somaxconn",
+//	   "selector": {...},
+//	   "images": [...],
+//	   "imagesMatch": "all",
+//	   "serviceAccounts": [...]
+//	}
+//
+// The scope has the same meaning as the one of allowedUnsafeSysctls.
+type RuleMatch struct {
+	// The sysctl name or pattern, `*` matches all the sysctls
+	Sysctl          string         `json:"sysctl"`
+	Selector        *LabelSelector `json:"selector,omitempty"`
+	Images          []string       `json:"images,omitempty"`
+	ImagesMatch     string         `json:"imagesMatch,omitempty"`
+	ServiceAccounts []string       `json:"serviceAccounts,omitempty"`
+}
+
+func (m *RuleMatch) UnmarshalJSON(data []byte) error {
+	sysctl := ""
+	if err := json.Unmarshal(data, &sysctl); err == nil {
+		*m = RuleMatch{Sysctl: sysctl}
+		return nil
+	}
+
+	type ruleMatchAlias RuleMatch
+	match := ruleMatchAlias{}
+	if err := json.Unmarshal(data, &match); err != nil {
+		return fmt.Errorf("rules match must be either a string or an object: %w", err)
+	}
+	*m = RuleMatch(match)
+	return nil
+}
+
+func (m RuleMatch) MarshalJSON() ([]byte, error) {
+	if !m.scope().scoped() {
+		return json.Marshal(m.Sysctl)
+	}
+
+	type ruleMatchAlias RuleMatch
+	return json.Marshal(ruleMatchAlias(m))
+}
+
+// scope returns the allowedUnsafeSysctls entry with the same scope, used to
+// share its validation and matching.
+func (m RuleMatch) scope() AllowedUnsafeSysctl {
+	return AllowedUnsafeSysctl{
+		Name:            m.Sysctl,
+		Selector:        m.Selector,
+		Images:          m.Images,
+		ImagesMatch:     m.ImagesMatch,
+		ServiceAccounts: m.ServiceAccounts,
+	}
+}
+
+// matches returns true when the sysctl used by the given workload is
+// matched.
+func (m RuleMatch) matches(sysctl string, w *workload) bool {
+	if m.Sysctl != sysctl &&
+		!(isSysctlPattern(m.Sysctl) && matchesSysctlPattern(m.Sysctl, sysctl)) {
+		return false
+	}
+	return m.scope().matches(w)
+}
+
+// covers returns true when all the sysctls and the Pods matched by other
+// are matched too.
+func (m RuleMatch) covers(other RuleMatch) bool {
+	if m.Sysctl != other.Sysctl &&
+		!(isSysctlPattern(m.Sysctl) && matchesSysctlPattern(m.Sysctl, strings.TrimSuffix(other.Sysctl, "*"))) {
+		return false
+	}
+	if !m.scope().scoped() {
+		return true
+	}
+	scope, otherScope := m.scope(), other.scope()
+	scope.Name, otherScope.Name = "", ""
+	return reflect.DeepEqual(scope, otherScope)
+}
+
+// Valid returns an error when the rule is not well formed.
+func (r *Rule) Valid() error {
+	if r.Match.Sysctl == "" {
+		return fmt.Errorf("match cannot be empty")
+	}
+	if strings.Contains(r.Match.Sysctl, "*") && !isSysctlPattern(r.Match.Sysctl) {
+		return fmt.Errorf("match only accepts patterns with `*` as suffix")
+	}
+	switch r.Action {
+	case ActionAllow, ActionDeny, ActionWarn, ActionLog:
+	default:
+		return fmt.Errorf("action must be one of `%s`, `%s`, `%s` or `%s`",
+			ActionAllow, ActionDeny, ActionWarn, ActionLog)
+	}
+	if err := r.Match.scope().Valid(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validRules returns an error when the rules are not well formed, or when a
+// rule can never be matched because of the ones before it.
+func validRules(rules []Rule) error {
+	for i := range rules {
+		if err := rules[i].Valid(); err != nil {
+			return fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		for j := 0; j < i; j++ {
+			if rules[j].Match.covers(rules[i].Match) {
+				return fmt.Errorf("rules[%d] is unreachable, rules[%d] matches all the sysctls it matches", i, j)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSysctlWithRules returns the violation caused by the given sysctl
+// according to the rules list, none when the sysctl can be used. The first
+// rule matching the sysctl decides, a sysctl not matched by any rule can be
+// used only when it's on the safe list. When breakGlass is set, only the
+// `allow` rules are evaluated.
+func validateSysctlWithRules(settings *Settings, entry *corev1.Sysctl, w *workload, breakGlass bool) []*violation {
+	sysctl := *entry.Name
+	value := sysctlValue(entry)
+
+	if index := firstMatchingRule(settings.Rules, sysctl, w, breakGlass); index >= 0 {
+		rule := settings.Rules[index]
+		if rule.Action == ActionAllow {
+			return []*violation{}
+		}
+
+		kind := ViolationForbiddenExact
+		if rule.Match.Sysctl != sysctl {
+			kind = ViolationForbiddenPattern
+		}
+		return []*violation{{
+			sysctl:    sysctl,
+			value:     value,
+			message:   fmt.Sprintf("sysctl %s is forbidden by rules[%d]", sysctl, index),
+			kind:      kind,
+			rule:      rule.forbiddenSysctl(),
+			ruleIndex: index,
+		}}
+	}
+
+	if CreateSafeSysctlsSet().Contains(sysctl) {
+		return []*violation{}
+	}
+	return []*violation{{
+		sysctl:    sysctl,
+		value:     value,
+		message:   fmt.Sprintf("sysctl %s is not on safe list, nor is allowed by the rules", sysctl),
+		kind:      ViolationNotAllowed,
+		ruleIndex: -1,
+	}}
+}
+
+// forbiddenSysctl returns the rule as a forbiddenSysctls entry, reported by
+// the violations.
+func (r *Rule) forbiddenSysctl() ForbiddenSysctl {
+	return ForbiddenSysctl{
+		Name:         r.Match.Sysctl,
+		RuleMetadata: r.RuleMetadata,
+		Action:       r.Action,
+		Message:      r.Message,
+	}
+}
+
+// firstMatchingRule returns the index of the first of the rules matching the
+// sysctl used by the given workload, -1 when there's none. When breakGlass is
+// set, only the `allow` rules are evaluated.
+func firstMatchingRule(rules []Rule, sysctl string, w *workload, breakGlass bool) int {
+	for index, rule := range rules {
+		if breakGlass && rule.Action != ActionAllow {
+			continue
+		}
+		if rule.Match.matches(sysctl, w) {
+			return index
+		}
+	}
+	return -1
+}
+
+// ruleSource is the forbiddenSysctls entry a compiled rule comes from, used
+// to report its violations like the legacy settings do.
+type ruleSource struct {
+	entry ForbiddenSysctl
+	// index of the entry in forbiddenSysctls, -1 for the rules compiled from
+	// allowedUnsafeSysctls
+	index int
+	// set when the rule denies the sysctls matched by a `warn` or `log`
+	// entry, because they are neither on the safe list nor allowed
+	notAllowed bool
+}
+
+// compiledRules returns the allowedUnsafeSysctls and forbiddenSysctls
+// compiled into a rules list taking the same decisions, along with the
+// source of each rule:
+//
+//  1. the exact forbiddenSysctls entries
+//  2. the allowedUnsafeSysctls entries
+//  3. the forbiddenSysctls patterns, the most specific first
+//
+// A forbiddenSysctls entry whose action is `warn` or `log` only accepts the
+// sysctls that are on the safe list, the others are denied as they are not
+// allowed. The settings must be valid.
+func (s *Settings) compiledRules() ([]Rule, []ruleSource) {
+	safe := CreateSafeSysctlsSet()
+	forbidden := s.forbiddenSysctlsList()
+	exact := mapset.NewThreadUnsafeSet[string]()
+	rules := []Rule{}
+	sources := []ruleSource{}
+
+	for index, entry := range forbidden {
+		if isSysctlPattern(entry.Name) {
+			continue
+		}
+		exact.Add(entry.Name)
+		rule := Rule{Match: RuleMatch{Sysctl: entry.Name}, Action: entry.action()}
+		source := ruleSource{entry: entry, index: index}
+		// a sysctl cannot be both allowed and forbidden, it's accepted only
+		// when it's on the safe list
+		if rule.Action != ActionDeny && !safe.Contains(entry.Name) {
+			rule.Action = ActionDeny
+			source.notAllowed = true
+		}
+		rules = append(rules, rule)
+		sources = append(sources, source)
+	}
+
+	unscoped := mapset.NewThreadUnsafeSet[string]()
+	for _, entry := range s.allowedUnsafeSysctlsList() {
+		if !entry.scoped() {
+			unscoped.Add(entry.Name)
+		}
+		rules = append(rules, Rule{
+			Match: RuleMatch{
+				Sysctl:          entry.Name,
+				Selector:        entry.Selector,
+				Images:          entry.Images,
+				ImagesMatch:     entry.ImagesMatch,
+				ServiceAccounts: entry.ServiceAccounts,
+			},
+			Action: ActionAllow,
+		})
+		sources = append(sources, ruleSource{index: -1})
+	}
+
+	patterns := []int{}
+	for index, entry := range forbidden {
+		if isSysctlPattern(entry.Name) {
+			patterns = append(patterns, index)
+		}
+	}
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return len(forbidden[patterns[i]].Name) > len(forbidden[patterns[j]].Name)
+	})
+	safeSysctls := safe.ToSlice()
+	sort.Strings(safeSysctls)
+	for i, index := range patterns {
+		entry := forbidden[index]
+		rule := Rule{Match: RuleMatch{Sysctl: entry.Name}, Action: entry.action()}
+		source := ruleSource{entry: entry, index: index}
+		if rule.Action != ActionDeny {
+			// only the safe sysctls not handled by the previous rules are
+			// accepted
+			for _, sysctl := range safeSysctls {
+				if !matchesSysctlPattern(entry.Name, sysctl) || exact.Contains(sysctl) ||
+					unscoped.Contains(sysctl) || matchedByPatterns(forbidden, patterns[:i], sysctl) {
+					continue
+				}
+				rules = append(rules, Rule{Match: RuleMatch{Sysctl: sysctl}, Action: rule.Action})
+				sources = append(sources, source)
+			}
+			rule.Action = ActionDeny
+			source.notAllowed = true
+		}
+		rules = append(rules, rule)
+		sources = append(sources, source)
+	}
+
+	return rules, sources
+}
+
+// matchedByPatterns returns true when one of the forbiddenSysctls patterns
+// at the given indexes matches the sysctl.
+func matchedByPatterns(forbidden []ForbiddenSysctl, patterns []int, sysctl string) bool {
+	for _, index := range patterns {
+		if matchesSysctlPattern(forbidden[index].Name, sysctl) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,374 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	metav1 "github.com/kubewarden/k8s-objects/apimachinery/pkg/apis/meta/v1"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestRulesAreValid(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		settings string
+		error    string
+	}{
+		{
+			name: "valid rules",
+			settings: `{"rules": [
+				{"match": "net.core.somaxconn", "action": "allow"},
+				{"match": {"sysctl": "net.*", "serviceAccounts": ["kube-system/cilium"]}, "action": "allow"},
+				{"match": "net.ipv4.*", "action": "warn"},
+				{"match": "net.*", "action": "deny"}
+			]}`,
+		},
+		{
+			name: "rule shadowed by a pattern",
+			settings: `{"rules": [
+				{"match": "net.*", "action": "deny"},
+				{"match": "net.core.somaxconn", "action": "allow"}
+			]}`,
+			error: "rules[1] is unreachable, rules[0] matches all the sysctls it matches",
+		},
+		{
+			name: "rule shadowed by *",
+			settings: `{"rules": [
+				{"match": "*", "action": "deny"},
+				{"match": "kernel.*", "action": "allow"}
+			]}`,
+			error: "rules[1] is unreachable, rules[0] matches all the sysctls it matches",
+		},
+		{
+			name: "rule shadowed by the same sysctl and scope",
+			settings: `{"rules": [
+				{"match": {"sysctl": "net.*", "serviceAccounts": ["kube-system/cilium"]}, "action": "allow"},
+				{"match": {"sysctl": "net.core.somaxconn", "serviceAccounts": ["kube-system/cilium"]}, "action": "deny"}
+			]}`,
+			error: "rules[1] is unreachable, rules[0] matches all the sysctls it matches",
+		},
+		{
+			name: "unknown action",
+			settings: `{"rules": [
+				{"match": "net.*", "action": "reject"}
+			]}`,
+			error: "rules[0]: action must be one of `allow`, `deny`, `warn` or `log`",
+		},
+		{
+			name: "pattern with * not as suffix",
+			settings: `{"rules": [
+				{"match": "net.*.somaxconn", "action": "deny"}
+			]}`,
+			error: "rules[0]: match only accepts patterns with `*` as suffix",
+		},
+		{
+			name: "rules together with the legacy lists",
+			settings: `{
+				"rules": [{"match": "net.*", "action": "deny"}],
+				"allowedUnsafeSysctls": ["kernel.msgmax"]
+			}`,
+			error: "rules cannot be used together with allowedUnsafeSysctls and forbiddenSysctls",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(tcase.settings))
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			valid, err := settings.Valid()
+			if tcase.error == "" {
+				if !valid || err != nil {
+					t.Errorf("got unexpected error '%v'", err)
+				}
+				return
+			}
+			if valid || err == nil || err.Error() != tcase.error {
+				t.Errorf("got error '%v', wanted '%s'", err, tcase.error)
+			}
+		})
+	}
+}
+
+func TestRulesFirstMatchWins(t *testing.T) {
+	payload := `{"rules": [
+		{"match": "net.core.somaxconn", "action": "allow"},
+		{"match": "net.ipv4.ip_local_port_range", "action": "warn", "id": "CR-2"},
+		{"match": "net.*", "action": "deny", "message": "ask the network team"},
+		{"match": "kernel.msgmax", "action": "allow"}
+	]}`
+
+	for _, tcase := range []struct {
+		sysctl     string
+		breakGlass bool
+		outcome    string
+		message    string
+	}{
+		{sysctl: "net.core.somaxconn", outcome: ActionAllow},
+		{
+			sysctl:  "net.ipv4.ip_local_port_range",
+			outcome: ActionWarn,
+			message: "sysctl net.ipv4.ip_local_port_range is forbidden by rules[1] (rule CR-2)",
+		},
+		{
+			sysctl:  "net.ipv4.tcp_syncookies",
+			outcome: ActionDeny,
+			message: "sysctl net.ipv4.tcp_syncookies is forbidden by rules[2]: ask the network team",
+		},
+		{sysctl: "kernel.msgmax", outcome: ActionAllow},
+		{sysctl: "kernel.shm_rmid_forced", outcome: ActionAllow},
+		{
+			sysctl:  "kernel.sem",
+			outcome: ActionDeny,
+			message: "sysctl kernel.sem is not on safe list, nor is allowed by the rules",
+		},
+		{sysctl: "net.ipv4.tcp_syncookies", breakGlass: true, outcome: ActionAllow},
+		{
+			sysctl:     "net.core.rmem_max",
+			breakGlass: true,
+			outcome:    ActionDeny,
+			message:    "sysctl net.core.rmem_max is not on safe list, nor is allowed by the rules",
+		},
+	} {
+		t.Run(fmt.Sprintf("%s break-glass %v", tcase.sysctl, tcase.breakGlass), func(t *testing.T) {
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(payload))
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			w := workload{
+				metadata: &metav1.ObjectMeta{Namespace: "default"},
+				podSpec:  &corev1.PodSpec{},
+			}
+
+			value := "1"
+			violations := validateSysctl(&settings, &corev1.Sysctl{Name: &tcase.sysctl, Value: &value}, &w, tcase.breakGlass)
+			if outcome := violationsOutcome(violations); outcome != tcase.outcome {
+				t.Errorf("got outcome %s, wanted %s", outcome, tcase.outcome)
+			}
+			if len(violations) != 0 && violations[0].Error() != tcase.message {
+				t.Errorf("got '%s' instead of '%s'", violations[0].Error(), tcase.message)
+			}
+		})
+	}
+}
+
+func TestRulesValidation(t *testing.T) {
+	settings := json.RawMessage(`{
+		"rules": [
+			{"match": "net.core.*", "action": "allow"},
+			{"match": "*", "action": "deny", "id": "CR-9"}
+		],
+		"structuredRejection": true
+	}`)
+
+	for _, tcase := range []struct {
+		fixture  string
+		accepted bool
+		message  string
+	}{
+		{
+			fixture:  "../test_data/request-pod-somaxconn.json",
+			accepted: true,
+		},
+		{
+			fixture: "../test_data/request-pod-safe-sysctls.json",
+			message: "sysctl kernel.shm_rmid_forced is forbidden by rules[1] (rule CR-9)\n" +
+				StructuredRejectionPrefix + `{"version":"v1","violations":[` +
+				`{"sysctl":"kernel.shm_rmid_forced","value":"foo","type":"forbidden-pattern","rule":"*","ruleIndex":1,` +
+				`"ruleId":"CR-9","message":"sysctl kernel.shm_rmid_forced is forbidden by rules[1] (rule CR-9)"},` +
+				`{"sysctl":"net.ipv4.ip_local_port_range","value":"bar","type":"forbidden-pattern","rule":"*","ruleIndex":1,` +
+				`"ruleId":"CR-9","message":"sysctl net.ipv4.ip_local_port_range is forbidden by rules[1] (rule CR-9)"},` +
+				`{"sysctl":"net.ipv4.tcp_syncookies","value":"baz","type":"forbidden-pattern","rule":"*","ruleIndex":1,` +
+				`"ruleId":"CR-9","message":"sysctl net.ipv4.tcp_syncookies is forbidden by rules[1] (rule CR-9)"},` +
+				`{"sysctl":"net.ipv4.ping_group_range","value":"bal","type":"forbidden-pattern","rule":"*","ruleIndex":1,` +
+				`"ruleId":"CR-9","message":"sysctl net.ipv4.ping_group_range is forbidden by rules[1] (rule CR-9)"}]}`,
+		},
+	} {
+		t.Run(tcase.fixture, func(t *testing.T) {
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(tcase.fixture, settings)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			responsePayload, err := Validate(payload)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			if response.Accepted != tcase.accepted {
+				t.Fatalf("got accepted %v, wanted %v", response.Accepted, tcase.accepted)
+			}
+			if !tcase.accepted && *response.Message != tcase.message {
+				t.Errorf("got '%s' instead of '%s'", *response.Message, tcase.message)
+			}
+		})
+	}
+}
+
+func TestCompiledRulesAreEquivalent(t *testing.T) {
+	sysctls := []string{
+		"kernel.shm_rmid_forced",
+		"kernel.msgmax",
+		"kernel.sem",
+		"net.core.somaxconn",
+		"net.core.rmem_max",
+		"net.ipv4.ip_local_port_range",
+		"net.ipv4.tcp_syncookies",
+		"net.ipv4.ping_group_range",
+		"net.ipv4.tcp_keepalive_time",
+		"vm.swappiness",
+	}
+	workloads := []workload{
+		{
+			metadata: &metav1.ObjectMeta{Namespace: "default"},
+			podSpec:  &corev1.PodSpec{},
+		},
+		{
+			metadata: &metav1.ObjectMeta{Namespace: "ingress", Labels: map[string]string{"tier": "lb"}},
+			podSpec:  &corev1.PodSpec{ServiceAccountName: "controller"},
+		},
+	}
+
+	for _, tcase := range []struct {
+		name     string
+		settings string
+	}{
+		{
+			name:     "empty",
+			settings: `{}`,
+		},
+		{
+			name: "allowed and forbidden",
+			settings: `{
+				"allowedUnsafeSysctls": ["net.core.somaxconn", "kernel.msgmax"],
+				"forbiddenSysctls": ["net.*", "kernel.shm_rmid_forced"]
+			}`,
+		},
+		{
+			name: "warn pattern",
+			settings: `{
+				"forbiddenSysctls": [{"name": "net.*", "action": "warn"}]
+			}`,
+		},
+		{
+			name: "nested patterns",
+			settings: `{
+				"allowedUnsafeSysctls": ["net.ipv4.tcp_keepalive_time"],
+				"forbiddenSysctls": [
+					"net.*",
+					{"name": "net.ipv4.*", "action": "warn"},
+					{"name": "net.ipv4.tcp_syncookies", "action": "log"},
+					{"name": "kernel.sem", "action": "warn"},
+					"*"
+				]
+			}`,
+		},
+		{
+			name: "scoped allowed entries",
+			settings: `{
+				"allowedUnsafeSysctls": [
+					{"name": "net.core.somaxconn", "selector": {"matchLabels": {"tier": "lb"}}},
+					{"name": "net.ipv4.ping_group_range", "serviceAccounts": ["ingress/controller"]},
+					"kernel.msgmax"
+				],
+				"forbiddenSysctls": [{"name": "net.*", "action": "log"}]
+			}`,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			legacy, err := NewSettingsFromValidateSettingsPayload([]byte(tcase.settings))
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if valid, err := legacy.Valid(); !valid {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			rules, _ := legacy.compiledRules()
+			compiled := Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet[string](),
+				Rules:                rules,
+			}
+			if len(compiled.Rules) != 0 {
+				if valid, err := compiled.Valid(); !valid {
+					t.Fatalf("compiled rules %+v are not valid: %v", compiled.Rules, err)
+				}
+			}
+
+			for _, sysctl := range sysctls {
+				for i := range workloads {
+					value := "1"
+					entry := corev1.Sysctl{Name: &sysctl, Value: &value}
+					expected := legacyOutcome(&legacy, sysctl, &workloads[i])
+					if got := violationsOutcome(validateSysctl(&legacy, &entry, &workloads[i], false)); got != expected {
+						t.Errorf("%s used by workload %d: got %s, wanted %s", sysctl, i, got, expected)
+					}
+					if got := violationsOutcome(validateSysctl(&compiled, &entry, &workloads[i], false)); got != expected {
+						t.Errorf("%s used by workload %d with the compiled rules: got %s, wanted %s",
+							sysctl, i, got, expected)
+					}
+				}
+			}
+		})
+	}
+}
+
+// legacyOutcome returns the outcome of the sysctl used by the workload
+// according to the precedence of allowedUnsafeSysctls and forbiddenSysctls:
+// an exact forbiddenSysctls entry wins, then allowedUnsafeSysctls, then the
+// most specific forbiddenSysctls pattern. A sysctl neither safe nor allowed
+// is denied.
+func legacyOutcome(settings *Settings, sysctl string, w *workload) string {
+	_, allowed := settings.allowingRule(sysctl, w)
+	action := ""
+	if settings.ForbiddenSysctls.Contains(sysctl) {
+		action = settings.forbiddenSysctlRule(sysctl).action()
+	} else if !allowed {
+		pattern := ""
+		for _, elem := range settings.ForbiddenSysctls.ToSlice() {
+			if isSysctlPattern(elem) && matchesSysctlPattern(elem, sysctl) && len(elem) > len(pattern) {
+				pattern = elem
+			}
+		}
+		if pattern != "" {
+			action = settings.forbiddenSysctlRule(pattern).action()
+		}
+	}
+
+	switch {
+	case action == ActionDeny:
+		return ActionDeny
+	case !allowed && !CreateSafeSysctlsSet().Contains(sysctl):
+		return ActionDeny
+	case action == "":
+		return ActionAllow
+	}
+	return action
+}
+
+// violationsOutcome returns the most severe action of the given violations,
+// `allow` when there are none.
+func violationsOutcome(violations []*violation) string {
+	outcome := ActionAllow
+	for _, v := range violations {
+		switch {
+		case v.action() == ActionDeny:
+			return ActionDeny
+		case v.action() == ActionWarn:
+			outcome = ActionWarn
+		case v.action() == ActionLog && outcome == ActionAllow:
+			outcome = ActionLog
+		}
+	}
+	return outcome
+}
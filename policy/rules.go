@@ -0,0 +1,236 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// ActionDeny rejects the request
+	ActionDeny = "deny"
+	// ActionWarn accepts the request, returning an admission warning
+	ActionWarn = "warn"
+	// ActionLog accepts the request, the violation is only logged
+	ActionLog = "log"
+)
+
+// RuleMetadata holds the optional identification of a rule, used to trace
+// the decisions back to the change that introduced the rule.
+type RuleMetadata struct {
+	// Identifier of the rule, unique across the settings
+	ID string `json:"id,omitempty"`
+	// Human readable description of the rule
+	Description string `json:"description,omitempty"`
+}
+
+// empty returns true when neither the id nor the description are set.
+func (m RuleMetadata) empty() bool {
+	return m == RuleMetadata{}
+}
+
+// reference returns the suffix identifying the rule in messages, empty when
+// the rule has no id.
+func (m RuleMetadata) reference() string {
+	if m.ID == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (rule %s)", m.ID)
+}
+
+// ForbiddenSysctl is an entry of the forbiddenSysctls list. It can be
+// provided either as a plain string, the sysctl name or pattern, or as an
+// object:
+//
+//	{
+//	   "name": "net.*",
+//	   "action": "warn",
+//	   "message": "...",
+//	   "id": "CR-1234",
+//	   "description": "..."
+//	}
+type ForbiddenSysctl struct {
+	Name string `json:"name"`
+	RuleMetadata
+	// Either `deny` (the default), `warn` or `log`
+	Action string `json:"action,omitempty"`
+	// Optional message shown to the user when the rule is violated
+	Message string `json:"message,omitempty"`
+}
+
+func (f *ForbiddenSysctl) UnmarshalJSON(data []byte) error {
+	name := ""
+	if err := json.Unmarshal(data, &name); err == nil {
+		*f = ForbiddenSysctl{Name: name}
+		return nil
+	}
+
+	type forbiddenSysctlAlias ForbiddenSysctl
+	rule := forbiddenSysctlAlias{}
+	if err := json.Unmarshal(data, &rule); err != nil {
+		return fmt.Errorf("forbiddenSysctls entries must be either a string or an object: %w", err)
+	}
+	*f = ForbiddenSysctl(rule)
+	return nil
+}
+
+func (f ForbiddenSysctl) MarshalJSON() ([]byte, error) {
+	if f.plain() {
+		return json.Marshal(f.Name)
+	}
+
+	type forbiddenSysctlAlias ForbiddenSysctl
+	return json.Marshal(forbiddenSysctlAlias(f))
+}
+
+// plain returns true when the rule only has a name.
+func (f ForbiddenSysctl) plain() bool {
+	return f == ForbiddenSysctl{Name: f.Name}
+}
+
+// action returns the action of the rule, `deny` when not set.
+func (f ForbiddenSysctl) action() string {
+	if f.Action == "" {
+		return ActionDeny
+	}
+	return f.Action
+}
+
+// DiscouragedSysctl is an entry of the discouragedSysctls list.
+type DiscouragedSysctl struct {
+	// The sysctl name or pattern
+	Name string `json:"name"`
+	RuleMetadata
+	// Why the sysctl is discouraged, shown to the user
+	Reason string `json:"reason,omitempty"`
+}
+
+// AllowedUnsafeSysctl is an entry of the allowedUnsafeSysctls list. It can be
+// provided either as a plain string, the sysctl name, or as an object that
+// scopes the sysctl to some Pods:
+//
+//	{
+//	   "name": "net.core.somaxconn",
+//	   "selector": {
+//	      "matchLabels": {...},
+//	      "matchExpressions": [...]
+//	   },
+//	   "images": ["registry.example.com/lb/*"],
+//	   "imagesMatch": "all",
+//	   "serviceAccounts": ["monitoring/node-tuner"],
+//	   "id": "CR-1234",
+//	   "description": "..."
+//	}
+//
+// A Pod must satisfy all the constraints of the scope.
+type AllowedUnsafeSysctl struct {
+	Name string `json:"name"`
+	RuleMetadata
+	// Only the Pods whose labels match the selector can use the sysctl
+	Selector *LabelSelector `json:"selector,omitempty"`
+	// Only the Pods whose container images match these patterns can use the
+	// sysctl
+	Images []string `json:"images,omitempty"`
+	// Either `all` (the default), every container of the Pod must match
+	// images, or `any`, at least one container must match
+	ImagesMatch string `json:"imagesMatch,omitempty"`
+	// Only the Pods running as one of these service accounts, in the
+	// `namespace/name` form, can use the sysctl
+	ServiceAccounts []string `json:"serviceAccounts,omitempty"`
+}
+
+func (a *AllowedUnsafeSysctl) UnmarshalJSON(data []byte) error {
+	name := ""
+	if err := json.Unmarshal(data, &name); err == nil {
+		*a = AllowedUnsafeSysctl{Name: name}
+		return nil
+	}
+
+	type allowedUnsafeSysctlAlias AllowedUnsafeSysctl
+	rule := allowedUnsafeSysctlAlias{}
+	if err := json.Unmarshal(data, &rule); err != nil {
+		return fmt.Errorf("allowedUnsafeSysctls entries must be either a string or an object: %w", err)
+	}
+	*a = AllowedUnsafeSysctl(rule)
+	return nil
+}
+
+func (a AllowedUnsafeSysctl) MarshalJSON() ([]byte, error) {
+	if !a.scoped() && a.RuleMetadata.empty() {
+		return json.Marshal(a.Name)
+	}
+
+	type allowedUnsafeSysctlAlias AllowedUnsafeSysctl
+	return json.Marshal(allowedUnsafeSysctlAlias(a))
+}
+
+// scoped returns true when the rule doesn't apply to all the Pods.
+func (a AllowedUnsafeSysctl) scoped() bool {
+	return a.Selector != nil || len(a.Images) != 0 || len(a.ServiceAccounts) != 0
+}
+
+// Valid returns an error when the scope of the rule is not well formed.
+func (a AllowedUnsafeSysctl) Valid() error {
+	if a.Selector != nil {
+		if err := a.Selector.Valid(); err != nil {
+			return fmt.Errorf("selector: %w", err)
+		}
+	}
+
+	for _, image := range a.Images {
+		if err := validImagePattern(image); err != nil {
+			return err
+		}
+	}
+	switch a.ImagesMatch {
+	case "", ImagesMatchAll, ImagesMatchAny:
+	default:
+		return fmt.Errorf("imagesMatch must be either `%s` or `%s`", ImagesMatchAll, ImagesMatchAny)
+	}
+	if a.ImagesMatch != "" && len(a.Images) == 0 {
+		return fmt.Errorf("imagesMatch requires images")
+	}
+
+	for _, serviceAccount := range a.ServiceAccounts {
+		namespace, name, found := strings.Cut(serviceAccount, "/")
+		if !found || namespace == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("serviceAccounts entry %q must be in the `namespace/name` form", serviceAccount)
+		}
+	}
+
+	return nil
+}
+
+// matches returns true when the given workload is in the scope of the rule.
+func (a AllowedUnsafeSysctl) matches(w *workload) bool {
+	if a.Selector != nil && !a.Selector.Matches(w.metadata.Labels) {
+		return false
+	}
+	if len(a.Images) != 0 && !a.matchesImages(podImages(w.podSpec)) {
+		return false
+	}
+	if len(a.ServiceAccounts) != 0 && !slices.Contains(a.ServiceAccounts, w.serviceAccount()) {
+		return false
+	}
+	return true
+}
+
+// matchesImages returns true when the given images satisfy the images
+// constraint of the rule.
+func (a AllowedUnsafeSysctl) matchesImages(images []string) bool {
+	matched := 0
+	for _, image := range images {
+		for _, pattern := range a.Images {
+			if matchesImagePattern(pattern, image) {
+				matched++
+				break
+			}
+		}
+	}
+
+	if a.ImagesMatch == ImagesMatchAny {
+		return matched != 0
+	}
+	return len(images) != 0 && matched == len(images)
+}
@@ -0,0 +1,42 @@
+package policy
+
+import (
+	"testing"
+)
+
+func TestAllowedUnsafeSysctlMatchesImages(t *testing.T) {
+	images := []string{"registry.example.com/db/postgres:15", "busybox"}
+
+	for _, tcase := range []struct {
+		name    string
+		rule    AllowedUnsafeSysctl
+		matches bool
+	}{
+		{
+			name:    "all containers must match by default",
+			rule:    AllowedUnsafeSysctl{Images: []string{"registry.example.com/db/*"}},
+			matches: false,
+		},
+		{
+			name:    "all containers match",
+			rule:    AllowedUnsafeSysctl{Images: []string{"registry.example.com/db/*", "busybox"}},
+			matches: true,
+		},
+		{
+			name:    "at least one container matches",
+			rule:    AllowedUnsafeSysctl{Images: []string{"registry.example.com/db/*"}, ImagesMatch: ImagesMatchAny},
+			matches: true,
+		},
+		{
+			name:    "no container matches",
+			rule:    AllowedUnsafeSysctl{Images: []string{"registry.example.com/lb/*"}, ImagesMatch: ImagesMatchAny},
+			matches: false,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			if matches := tcase.rule.matchesImages(images); matches != tcase.matches {
+				t.Errorf("got %v, wanted %v", matches, tcase.matches)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package policy_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	metav1 "github.com/kubewarden/k8s-objects/apimachinery/pkg/apis/meta/v1"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+
+	"github.com/kubewarden/go-policy-template/policy"
+	"github.com/kubewarden/go-policy-template/scc"
+)
+
+func TestConvertedSecurityContextConstraintsRoundTrip(t *testing.T) {
+	for _, fixture := range []string{
+		"../test_data/scc/sccs.yaml",
+		"../test_data/scc/list.json",
+	} {
+		t.Run(fixture, func(t *testing.T) {
+			data, err := os.ReadFile(fixture)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			results, err := scc.Convert(data)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			for _, result := range results {
+				policy.RoundTrip(t, result.Settings)
+			}
+
+			scoped, err := scc.ConvertScoped(data)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			policy.RoundTrip(t, scoped.Settings)
+		})
+	}
+}
+
+func TestScopedSecurityContextConstraintsValidation(t *testing.T) {
+	data, err := os.ReadFile("../test_data/scc/sccs.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	scoped, err := scc.ConvertScoped(data)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	settings := policy.RoundTrip(t, scoped.Settings)
+
+	for _, tcase := range []struct {
+		name           string
+		namespace      string
+		serviceAccount string
+		sysctl         string
+		accepted       bool
+	}{
+		{
+			name:           "service account granted the sysctl",
+			namespace:      "db",
+			serviceAccount: "postgres",
+			sysctl:         "kernel.shmmax",
+			accepted:       true,
+		},
+		{
+			name:           "service account granted another sysctl",
+			namespace:      "ingress",
+			serviceAccount: "router",
+			sysctl:         "kernel.shmmax",
+		},
+		{
+			name:           "service account not granted any sysctl",
+			namespace:      "default",
+			serviceAccount: "default",
+			sysctl:         "net.core.somaxconn",
+		},
+		{
+			name:           "safe sysctl",
+			namespace:      "default",
+			serviceAccount: "default",
+			sysctl:         "net.ipv4.ping_group_range",
+			accepted:       true,
+		},
+		{
+			name:           "safe sysctl forbidden by all the SecurityContextConstraints",
+			namespace:      "db",
+			serviceAccount: "postgres",
+			sysctl:         "kernel.shm_rmid_forced",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			value := "1"
+			pod := corev1.Pod{
+				Metadata: &metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: tcase.namespace,
+				},
+				Spec: &corev1.PodSpec{
+					ServiceAccountName: tcase.serviceAccount,
+					SecurityContext: &corev1.PodSecurityContext{
+						Sysctls: []*corev1.Sysctl{{Name: &tcase.sysctl, Value: &value}},
+					},
+				},
+			}
+			object, err := json.Marshal(&pod)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+			payload, err := policy.BuildPodValidationRequest(object, &settings)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			responsePayload, err := policy.Validate(payload)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+			if response.Accepted != tcase.accepted {
+				t.Errorf("got accepted %v, wanted %v: %v", response.Accepted, tcase.accepted, response.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package policy
+
+import (
+	"fmt"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+const (
+	LabelSelectorOpIn           = "In"
+	LabelSelectorOpNotIn        = "NotIn"
+	LabelSelectorOpExists       = "Exists"
+	LabelSelectorOpDoesNotExist = "DoesNotExist"
+)
+
+// LabelSelector is a Kubernetes label selector. An empty selector matches
+// everything.
+type LabelSelector struct {
+	MatchLabels      map[string]string          `json:"matchLabels,omitempty"`
+	MatchExpressions []LabelSelectorRequirement `json:"matchExpressions,omitempty"`
+}
+
+// LabelSelectorRequirement is a requirement of a Kubernetes label selector.
+type LabelSelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// Valid returns an error when the selector doesn't follow the same rules
+// enforced by Kubernetes.
+func (s *LabelSelector) Valid() error {
+	for key := range s.MatchLabels {
+		if key == "" {
+			return fmt.Errorf("matchLabels keys cannot be empty")
+		}
+	}
+
+	for _, requirement := range s.MatchExpressions {
+		if requirement.Key == "" {
+			return fmt.Errorf("matchExpressions keys cannot be empty")
+		}
+
+		switch requirement.Operator {
+		case LabelSelectorOpIn, LabelSelectorOpNotIn:
+			if len(requirement.Values) == 0 {
+				return fmt.Errorf("matchExpressions key %s: values must be non-empty when operator is %s",
+					requirement.Key, requirement.Operator)
+			}
+		case LabelSelectorOpExists, LabelSelectorOpDoesNotExist:
+			if len(requirement.Values) != 0 {
+				return fmt.Errorf("matchExpressions key %s: values must be empty when operator is %s",
+					requirement.Key, requirement.Operator)
+			}
+		default:
+			return fmt.Errorf("matchExpressions key %s: %q is not a valid label selector operator",
+				requirement.Key, requirement.Operator)
+		}
+	}
+
+	return nil
+}
+
+// Matches returns true when the given labels satisfy all the requirements of
+// the selector.
+func (s *LabelSelector) Matches(labels map[string]string) bool {
+	for key, value := range s.MatchLabels {
+		if actual, found := labels[key]; !found || actual != value {
+			return false
+		}
+	}
+
+	for _, requirement := range s.MatchExpressions {
+		value, found := labels[requirement.Key]
+		values := mapset.NewThreadUnsafeSet(requirement.Values...)
+
+		switch requirement.Operator {
+		case LabelSelectorOpIn:
+			if !found || !values.Contains(value) {
+				return false
+			}
+		case LabelSelectorOpNotIn:
+			if found && values.Contains(value) {
+				return false
+			}
+		case LabelSelectorOpExists:
+			if !found {
+				return false
+			}
+		case LabelSelectorOpDoesNotExist:
+			if found {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"testing"
+)
+
+func TestLabelSelectorMatches(t *testing.T) {
+	labels := map[string]string{
+		"app.kubernetes.io/component": "ingress",
+		"env":                         "prod",
+	}
+
+	for _, tcase := range []struct {
+		name     string
+		selector LabelSelector
+		matches  bool
+	}{
+		{
+			name:     "empty selector",
+			selector: LabelSelector{},
+			matches:  true,
+		},
+		{
+			name:     "matchLabels",
+			selector: LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/component": "ingress"}},
+			matches:  true,
+		},
+		{
+			name:     "matchLabels with different value",
+			selector: LabelSelector{MatchLabels: map[string]string{"env": "dev"}},
+			matches:  false,
+		},
+		{
+			name: "In",
+			selector: LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+				{Key: "env", Operator: LabelSelectorOpIn, Values: []string{"prod", "staging"}},
+			}},
+			matches: true,
+		},
+		{
+			name: "In with missing label",
+			selector: LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+				{Key: "tier", Operator: LabelSelectorOpIn, Values: []string{"frontend"}},
+			}},
+			matches: false,
+		},
+		{
+			name: "NotIn",
+			selector: LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+				{Key: "env", Operator: LabelSelectorOpNotIn, Values: []string{"prod"}},
+			}},
+			matches: false,
+		},
+		{
+			name: "NotIn with missing label",
+			selector: LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+				{Key: "tier", Operator: LabelSelectorOpNotIn, Values: []string{"frontend"}},
+			}},
+			matches: true,
+		},
+		{
+			name: "Exists and DoesNotExist",
+			selector: LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+				{Key: "env", Operator: LabelSelectorOpExists},
+				{Key: "tier", Operator: LabelSelectorOpDoesNotExist},
+			}},
+			matches: true,
+		},
+		{
+			name: "all requirements must match",
+			selector: LabelSelector{
+				MatchLabels: map[string]string{"env": "prod"},
+				MatchExpressions: []LabelSelectorRequirement{
+					{Key: "app.kubernetes.io/component", Operator: LabelSelectorOpDoesNotExist},
+				},
+			},
+			matches: false,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			if matches := tcase.selector.Matches(labels); matches != tcase.matches {
+				t.Errorf("got %v, wanted %v", matches, tcase.matches)
+			}
+		})
+	}
+}
+
+func TestLabelSelectorValid(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		selector LabelSelector
+		error    string
+	}{
+		{
+			name: "valid",
+			selector: LabelSelector{
+				MatchLabels: map[string]string{"env": "prod"},
+				MatchExpressions: []LabelSelectorRequirement{
+					{Key: "tier", Operator: LabelSelectorOpIn, Values: []string{"frontend"}},
+					{Key: "debug", Operator: LabelSelectorOpDoesNotExist},
+				},
+			},
+		},
+		{
+			name: "In without values",
+			selector: LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+				{Key: "tier", Operator: LabelSelectorOpIn},
+			}},
+			error: "matchExpressions key tier: values must be non-empty when operator is In",
+		},
+		{
+			name: "Exists with values",
+			selector: LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+				{Key: "tier", Operator: LabelSelectorOpExists, Values: []string{"frontend"}},
+			}},
+			error: "matchExpressions key tier: values must be empty when operator is Exists",
+		},
+		{
+			name: "unknown operator",
+			selector: LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+				{Key: "tier", Operator: "Equals", Values: []string{"frontend"}},
+			}},
+			error: `matchExpressions key tier: "Equals" is not a valid label selector operator`,
+		},
+		{
+			name: "empty key",
+			selector: LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+				{Operator: LabelSelectorOpExists},
+			}},
+			error: "matchExpressions keys cannot be empty",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			err := tcase.selector.Valid()
+			if tcase.error == "" {
+				if err != nil {
+					t.Errorf("got unexpected error '%v'", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tcase.error {
+				t.Errorf("got error '%v', wanted '%s'", err, tcase.error)
+			}
+		})
+	}
+}
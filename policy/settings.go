@@ -0,0 +1,587 @@
+package policy
+
+import (
+	"encoding/json"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/kubewarden/gjson"
+	kubewarden "github.com/kubewarden/policy-sdk-go"
+	"github.com/kubewarden/policy-sdk-go/protocol"
+
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// EnforcementModeDeny rejects the requests violating the policy
+	EnforcementModeDeny = "deny"
+	// EnforcementModeWarn accepts the requests violating the policy, the
+	// violations are logged and returned as admission warnings
+	EnforcementModeWarn = "warn"
+)
+
+// Settings of the policy. The zero value is usable, it has the same meaning
+// as empty settings.
+type Settings struct {
+	AllowedUnsafeSysctls mapset.Set[string] `json:"allowedUnsafeSysctls"`
+	// The allowedUnsafeSysctls provided in the object form, either scoped to
+	// some Pods or described, keyed by name
+	AllowedUnsafeSysctlRules map[string][]AllowedUnsafeSysctl `json:"-"`
+	ForbiddenSysctls         mapset.Set[string]               `json:"forbiddenSysctls"`
+	// The forbiddenSysctls provided in the object form, keyed by name
+	ForbiddenSysctlRules map[string]ForbiddenSysctl `json:"-"`
+	// When enabled, UPDATE requests only evaluate the sysctls that have been
+	// added or changed compared to the old object
+	GrandfatherExisting bool `json:"grandfatherExisting"`
+	// Either `deny` (the default) or `warn`
+	EnforcementMode string `json:"enforcementMode,omitempty"`
+	// Sysctls that can be used, but that cause an admission warning
+	DiscouragedSysctls []DiscouragedSysctl `json:"discouragedSysctls,omitempty"`
+	// Allows to bypass forbiddenSysctls by annotating the Pod
+	BreakGlass BreakGlass `json:"breakGlass"`
+	// Time-bounded exceptions allowing the matching Pods to use a sysctl
+	Exceptions []Exception `json:"exceptions,omitempty"`
+	// Namespaces, or globs like `cilium-*`, whose requests are not evaluated
+	ExemptNamespaces []string `json:"exemptNamespaces,omitempty"`
+	// Go text/template used to build the message of the rejections caused by
+	// policy violations
+	RejectionMessageTemplate string `json:"rejectionMessageTemplate,omitempty"`
+	// Code of the rejections caused by policy violations
+	RejectionCode uint16 `json:"rejectionCode,omitempty"`
+	// URL of the documentation, available to rejectionMessageTemplate
+	DocsURL string `json:"docsUrl,omitempty"`
+	// When enabled, the rejection message ends with the violations in a
+	// machine-readable form
+	StructuredRejection bool `json:"structuredRejection,omitempty"`
+	// When enabled, the decision trace is added to the rejection message, or
+	// returned as admission warnings when the request is accepted
+	Explain bool `json:"explain,omitempty"`
+	// Ordered list of rules, the first one matching a sysctl decides. It's
+	// an alternative to allowedUnsafeSysctls and forbiddenSysctls
+	Rules []Rule `json:"rules,omitempty"`
+	// Name of the built-in preset extended by allowedUnsafeSysctls and
+	// forbiddenSysctls
+	Preset string `json:"preset,omitempty"`
+
+	// The forbiddenSysctls names, in the order they have been provided
+	forbiddenSysctlsOrder []string
+}
+
+// Builds a new Settings instance starting from a validation
+// request payload:
+//
+//	{
+//	   "request": ...,
+//	   "settings": {
+//	      "allowedUnsafeSysctls": [...],
+//	      "forbiddenSysctls": [...]
+//	   }
+//	}
+func NewSettingsFromValidationReq(payload []byte) (Settings, error) {
+	settingsJson := gjson.GetBytes(payload, "settings")
+
+	settings := Settings{}
+	err := json.Unmarshal([]byte(settingsJson.Raw), &settings)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	return settings, nil
+}
+
+// Builds a new Settings instance starting from a Settings
+// payload:
+//
+//	{
+//	   "allowedUnsafeSysctls": [...],
+//	   "forbiddenSysctls": [...]
+//	}
+func NewSettingsFromValidateSettingsPayload(payload []byte) (Settings, error) {
+	settings := Settings{}
+	err := json.Unmarshal(payload, &settings)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	return settings, nil
+}
+
+func (s *Settings) UnmarshalJSON(data []byte) error {
+	// This is needed becaus golang-set v2.3.0 has a bug that prevents
+	// the correct unmarshalling of ThreadUnsafeSet types.
+	// All the other fields are unmarshalled by the alias type, which doesn't
+	// have the UnmarshalJSON method.
+	type settingsAlias Settings
+	rawSettings := struct {
+		AllowedUnsafeSysctls []AllowedUnsafeSysctl `json:"allowedUnsafeSysctls"`
+		ForbiddenSysctls     []ForbiddenSysctl     `json:"forbiddenSysctls"`
+		*settingsAlias
+	}{
+		settingsAlias: (*settingsAlias)(s),
+	}
+
+	err := json.Unmarshal(data, &rawSettings)
+	if err != nil {
+		return err
+	}
+	if preset, found := presets[s.Preset]; found {
+		rawSettings.AllowedUnsafeSysctls, rawSettings.ForbiddenSysctls =
+			preset.merge(rawSettings.AllowedUnsafeSysctls, rawSettings.ForbiddenSysctls)
+	}
+
+	s.AllowedUnsafeSysctls = mapset.NewThreadUnsafeSet[string]()
+	s.AllowedUnsafeSysctlRules = map[string][]AllowedUnsafeSysctl{}
+	unscoped := mapset.NewThreadUnsafeSet[string]()
+	for _, rule := range rawSettings.AllowedUnsafeSysctls {
+		s.AllowedUnsafeSysctls.Add(rule.Name)
+		if rule.scoped() || !rule.RuleMetadata.empty() {
+			s.AllowedUnsafeSysctlRules[rule.Name] = append(s.AllowedUnsafeSysctlRules[rule.Name], rule)
+		}
+		if !rule.scoped() {
+			unscoped.Add(rule.Name)
+		}
+	}
+	// a sysctl allowed without any scope can be used by all the Pods, only
+	// the described unscoped entries are kept
+	for _, name := range unscoped.ToSlice() {
+		described := []AllowedUnsafeSysctl{}
+		for _, rule := range s.AllowedUnsafeSysctlRules[name] {
+			if !rule.scoped() {
+				described = append(described, rule)
+			}
+		}
+		if len(described) == 0 {
+			delete(s.AllowedUnsafeSysctlRules, name)
+		} else {
+			s.AllowedUnsafeSysctlRules[name] = described
+		}
+	}
+
+	s.ForbiddenSysctls = mapset.NewThreadUnsafeSet[string]()
+	s.ForbiddenSysctlRules = map[string]ForbiddenSysctl{}
+	s.forbiddenSysctlsOrder = []string{}
+	for _, rule := range rawSettings.ForbiddenSysctls {
+		if s.ForbiddenSysctls.Add(rule.Name) {
+			s.forbiddenSysctlsOrder = append(s.forbiddenSysctlsOrder, rule.Name)
+		}
+		if !rule.plain() {
+			s.ForbiddenSysctlRules[rule.Name] = rule
+		}
+	}
+	if s.EnforcementMode == "" {
+		s.EnforcementMode = EnforcementModeDeny
+	}
+
+	return nil
+}
+
+func (s Settings) MarshalJSON() ([]byte, error) {
+	type settingsAlias Settings
+	rawSettings := struct {
+		AllowedUnsafeSysctls []AllowedUnsafeSysctl `json:"allowedUnsafeSysctls"`
+		ForbiddenSysctls     []ForbiddenSysctl     `json:"forbiddenSysctls"`
+		settingsAlias
+	}{
+		AllowedUnsafeSysctls: s.allowedUnsafeSysctlsList(),
+		ForbiddenSysctls:     s.forbiddenSysctlsList(),
+		settingsAlias:        settingsAlias(s),
+	}
+
+	return json.Marshal(rawSettings)
+}
+
+// allowedUnsafeSysctlsSet returns the allowedUnsafeSysctls names, an empty
+// set when they are not set, like in the zero value of Settings.
+func (s *Settings) allowedUnsafeSysctlsSet() mapset.Set[string] {
+	if s.AllowedUnsafeSysctls == nil {
+		return mapset.NewThreadUnsafeSet[string]()
+	}
+	return s.AllowedUnsafeSysctls
+}
+
+// forbiddenSysctlsSet returns the forbiddenSysctls names, an empty set when
+// they are not set, like in the zero value of Settings.
+func (s *Settings) forbiddenSysctlsSet() mapset.Set[string] {
+	if s.ForbiddenSysctls == nil {
+		return mapset.NewThreadUnsafeSet[string]()
+	}
+	return s.ForbiddenSysctls
+}
+
+// allowedUnsafeSysctlsList returns the allowedUnsafeSysctls entries sorted by
+// name, using the object form for the ones that have it.
+func (s *Settings) allowedUnsafeSysctlsList() []AllowedUnsafeSysctl {
+	rules := []AllowedUnsafeSysctl{}
+	if s.AllowedUnsafeSysctls == nil {
+		return rules
+	}
+
+	names := s.AllowedUnsafeSysctls.ToSlice()
+	sort.Strings(names)
+	for _, name := range names {
+		if described, found := s.AllowedUnsafeSysctlRules[name]; found {
+			rules = append(rules, described...)
+		} else {
+			rules = append(rules, AllowedUnsafeSysctl{Name: name})
+		}
+	}
+	return rules
+}
+
+// validRuleIDs returns an error when the same id is given to more than one
+// rule.
+func (s *Settings) validRuleIDs() error {
+	ids := []string{}
+	for _, rule := range s.allowedUnsafeSysctlsList() {
+		ids = append(ids, rule.ID)
+	}
+	for _, rule := range s.forbiddenSysctlsList() {
+		ids = append(ids, rule.ID)
+	}
+	for _, discouraged := range s.DiscouragedSysctls {
+		ids = append(ids, discouraged.ID)
+	}
+	for _, exception := range s.Exceptions {
+		ids = append(ids, exception.ID)
+	}
+	for _, rule := range s.Rules {
+		ids = append(ids, rule.ID)
+	}
+
+	seen := mapset.NewThreadUnsafeSet[string]()
+	for _, id := range ids {
+		if id != "" && !seen.Add(id) {
+			return fmt.Errorf("rule id %s is used more than once", id)
+		}
+	}
+	return nil
+}
+
+// allowedUnsafeSysctlScoped returns true when the allowedUnsafeSysctls entries
+// of the sysctl only apply to some Pods.
+func (s *Settings) allowedUnsafeSysctlScoped(sysctl string) bool {
+	for _, rule := range s.AllowedUnsafeSysctlRules[sysctl] {
+		if rule.scoped() {
+			return true
+		}
+	}
+	return false
+}
+
+// allowingRule returns the allowedUnsafeSysctls entry allowing the given
+// workload to use the sysctl, and whether there is one.
+func (s *Settings) allowingRule(sysctl string, w *workload) (AllowedUnsafeSysctl, bool) {
+	if !s.allowedUnsafeSysctlsSet().Contains(sysctl) {
+		return AllowedUnsafeSysctl{}, false
+	}
+
+	rules, found := s.AllowedUnsafeSysctlRules[sysctl]
+	if !found {
+		return AllowedUnsafeSysctl{Name: sysctl}, true
+	}
+	for _, rule := range rules {
+		if rule.matches(w) {
+			return rule, true
+		}
+	}
+	return AllowedUnsafeSysctl{}, false
+}
+
+// forbiddenSysctlsList returns the forbiddenSysctls entries in the order they
+// have been provided, or sorted by name when that's not known, using the
+// object form for the ones that have it.
+func (s *Settings) forbiddenSysctlsList() []ForbiddenSysctl {
+	rules := []ForbiddenSysctl{}
+	if s.ForbiddenSysctls == nil {
+		return rules
+	}
+
+	names := s.forbiddenSysctlsOrder
+	if len(names) != s.ForbiddenSysctls.Cardinality() ||
+		!s.ForbiddenSysctls.Contains(names...) {
+		names = s.ForbiddenSysctls.ToSlice()
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		rules = append(rules, s.forbiddenSysctlRule(name))
+	}
+	return rules
+}
+
+// forbiddenSysctlIndex returns the index of the forbiddenSysctls entry with
+// the given name, -1 when there's none.
+func (s *Settings) forbiddenSysctlIndex(name string) int {
+	for index, rule := range s.forbiddenSysctlsList() {
+		if rule.Name == name {
+			return index
+		}
+	}
+	return -1
+}
+
+// forbiddenSysctlRule returns the forbiddenSysctls rule with the given name.
+func (s *Settings) forbiddenSysctlRule(name string) ForbiddenSysctl {
+	if rule, found := s.ForbiddenSysctlRules[name]; found {
+		return rule
+	}
+	return ForbiddenSysctl{Name: name}
+}
+
+func (s *Settings) Valid() (bool, error) {
+	for _, elem := range s.allowedUnsafeSysctlsSet().ToSlice() {
+		if elem == "" {
+			return false,
+				fmt.Errorf("allowedUnsafeSysctls entries cannot be empty")
+		}
+		if strings.Contains(elem, "*") {
+			return false,
+				fmt.Errorf("allowedUnsafeSysctls doesn't accept patterns with `*`")
+		}
+		for _, rule := range s.AllowedUnsafeSysctlRules[elem] {
+			if err := rule.Valid(); err != nil {
+				return false,
+					fmt.Errorf("allowedUnsafeSysctls entry %s: %w", elem, err)
+			}
+		}
+	}
+
+	for _, elem := range s.forbiddenSysctlsSet().ToSlice() {
+		if elem == "" {
+			return false,
+				fmt.Errorf("forbiddenSysctls entries cannot be empty")
+		}
+		switch s.forbiddenSysctlRule(elem).action() {
+		case ActionDeny, ActionWarn, ActionLog:
+		default:
+			return false,
+				fmt.Errorf("forbiddenSysctls entry %s: action must be one of `%s`, `%s` or `%s`",
+					elem, ActionDeny, ActionWarn, ActionLog)
+		}
+		if strings.Contains(elem, "*") &&
+			!strings.HasSuffix(elem, "*") {
+			return false,
+				fmt.Errorf("forbiddenSysctls only accepts patterns with `*` as suffix")
+		}
+	}
+
+	for _, discouraged := range s.DiscouragedSysctls {
+		if discouraged.Name == "" {
+			return false,
+				fmt.Errorf("discouragedSysctls entries must have a name")
+		}
+		if strings.Contains(discouraged.Name, "*") && !isSysctlPattern(discouraged.Name) {
+			return false,
+				fmt.Errorf("discouragedSysctls only accepts patterns with `*` as suffix")
+		}
+	}
+
+	for index, exception := range s.Exceptions {
+		if err := exception.Valid(); err != nil {
+			return false,
+				fmt.Errorf("exceptions[%d]: %w", index, err)
+		}
+	}
+
+	for _, namespace := range s.ExemptNamespaces {
+		if _, err := path.Match(namespace, ""); err != nil || namespace == "" {
+			return false,
+				fmt.Errorf("exemptNamespaces entry %q is not a valid namespace or glob", namespace)
+		}
+	}
+
+	if _, found := presets[s.Preset]; s.Preset != "" && !found {
+		return false,
+			fmt.Errorf("preset must be one of `%s`", strings.Join(presetNames(), "`, `"))
+	}
+
+	if len(s.Rules) != 0 {
+		if s.Preset != "" {
+			return false,
+				fmt.Errorf("rules cannot be used together with preset")
+		}
+		if s.allowedUnsafeSysctlsSet().Cardinality() != 0 || s.forbiddenSysctlsSet().Cardinality() != 0 {
+			return false,
+				fmt.Errorf("rules cannot be used together with allowedUnsafeSysctls and forbiddenSysctls")
+		}
+		if err := validRules(s.Rules); err != nil {
+			return false, err
+		}
+	}
+
+	if err := s.validRuleIDs(); err != nil {
+		return false, err
+	}
+
+	if err := validRejectionSettings(s); err != nil {
+		return false, err
+	}
+
+	if s.BreakGlass.Enabled && len(s.BreakGlass.Groups) == 0 {
+		return false,
+			fmt.Errorf("breakGlass.groups cannot be empty when break-glass is enabled")
+	}
+
+	switch s.EnforcementMode {
+	case "", EnforcementModeDeny, EnforcementModeWarn:
+	default:
+		return false,
+			fmt.Errorf("enforcementMode must be either `%s` or `%s`",
+				EnforcementModeDeny, EnforcementModeWarn)
+	}
+
+	allowedAndForbidden := s.allowedUnsafeSysctlsSet().Intersect(s.forbiddenSysctlsSet())
+	if allowedAndForbidden.Cardinality() != 0 {
+		return false,
+			fmt.Errorf("these sysctls cannot be allowed and forbidden at the same time: %s",
+				strings.Join(allowedAndForbidden.ToSlice(), ","),
+			)
+	}
+
+	return true, nil
+}
+
+// Warnings returns the findings about settings that are valid, but that are
+// likely not doing what the user expects. For example, rules that are
+// redundant or that can never be reached because of the precedence between
+// allowedUnsafeSysctls and forbiddenSysctls.
+func (s *Settings) Warnings() []string {
+	warnings := []string{}
+	knownSafeSysctls := CreateSafeSysctlsSet()
+
+	allowed := s.allowedUnsafeSysctlsSet().ToSlice()
+	sort.Strings(allowed)
+	forbidden := s.forbiddenSysctlsSet().ToSlice()
+	sort.Strings(forbidden)
+	// the preset is meant to be extended, its patterns are expected to
+	// be overridden
+	fromPreset := mapset.NewThreadUnsafeSet(presets[s.Preset].ForbiddenSysctls...)
+
+	for _, sysctl := range allowed {
+		if knownSafeSysctls.Contains(sysctl) {
+			// allowing a safe sysctl is how it is carved out of a
+			// forbidden pattern
+			covered := false
+			for _, pattern := range forbidden {
+				covered = covered || (isSysctlPattern(pattern) && matchesSysctlPattern(pattern, sysctl))
+			}
+			if !covered {
+				warnings = append(warnings,
+					fmt.Sprintf("allowedUnsafeSysctls entry %s is already on the safe list", sysctl))
+			}
+			continue
+		}
+		if s.allowedUnsafeSysctlScoped(sysctl) {
+			// the patterns apply to the Pods outside of the scope
+			continue
+		}
+		// allowing a sysctl covered by a broader pattern is how it is
+		// carved out of it, only the patterns meant for the sysctl itself
+		// are reported
+		for _, pattern := range forbidden {
+			if isSysctlPattern(pattern) && strings.TrimSuffix(pattern, "*") == sysctl && !fromPreset.Contains(pattern) {
+				warnings = append(warnings,
+					fmt.Sprintf("forbiddenSysctls pattern %s never applies to %s, allowedUnsafeSysctls has precedence",
+						pattern, sysctl))
+			}
+		}
+	}
+
+	for _, elem := range forbidden {
+		for _, pattern := range forbidden {
+			if pattern == elem || !isSysctlPattern(pattern) {
+				continue
+			}
+			if matchesSysctlPattern(pattern, strings.TrimSuffix(elem, "*")) {
+				warnings = append(warnings,
+					fmt.Sprintf("forbiddenSysctls entry %s is redundant, it is already covered by %s",
+						elem, pattern))
+				break
+			}
+		}
+	}
+
+	for index, exception := range s.Exceptions {
+		if exception.expired() {
+			warnings = append(warnings,
+				fmt.Sprintf("exceptions[%d] for %s expired on %s and can be removed",
+					index, exception.Sysctl, exception.ValidUntil))
+		}
+	}
+
+	if s.forbiddenSysctlsSet().Contains("*") && !fromPreset.Contains("*") &&
+		s.allowedUnsafeSysctlsSet().Cardinality() != 0 {
+		warnings = append(warnings,
+			"forbiddenSysctls contains `*`: only the sysctls listed in allowedUnsafeSysctls can be used, "+
+				"including the ones on the safe list")
+	}
+
+	return warnings
+}
+
+// discouragedSysctl returns the discouragedSysctls entry matching the given
+// sysctl, if any.
+func (s *Settings) discouragedSysctl(sysctl string) (DiscouragedSysctl, bool) {
+	for _, discouraged := range s.DiscouragedSysctls {
+		if discouraged.Name == sysctl ||
+			(isSysctlPattern(discouraged.Name) && matchesSysctlPattern(discouraged.Name, sysctl)) {
+			return discouraged, true
+		}
+	}
+	return DiscouragedSysctl{}, false
+}
+
+// namespaceExempted returns true when the given namespace matches one of the
+// exemptNamespaces.
+func (s *Settings) namespaceExempted(namespace string) bool {
+	for _, pattern := range s.ExemptNamespaces {
+		if matched, err := path.Match(pattern, namespace); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isSysctlPattern returns true when the given forbiddenSysctls entry is a
+// pattern, i.e. it ends with `*`.
+func isSysctlPattern(elem string) bool {
+	return strings.HasSuffix(elem, "*")
+}
+
+// matchesSysctlPattern returns true when the sysctl is matched by the given
+// pattern.
+func matchesSysctlPattern(pattern, sysctl string) bool {
+	return strings.HasPrefix(sysctl, strings.TrimSuffix(pattern, "*"))
+}
+
+// ValidateSettings is the `validate_settings` entrypoint of the policy.
+func ValidateSettings(payload []byte) ([]byte, error) {
+	logger.Info("validating settings")
+
+	settings, err := NewSettingsFromValidateSettingsPayload(payload)
+	if err != nil {
+		return kubewarden.RejectSettings(
+			kubewarden.Message(fmt.Sprintf("provided settings are not valid: %v", err)))
+	}
+
+	valid, err := settings.Valid()
+	if valid {
+		warnings := settings.Warnings()
+		if len(warnings) == 0 {
+			return kubewarden.AcceptSettings()
+		}
+
+		logger.Warn("settings have warnings")
+		msg := fmt.Sprintf("provided settings are valid, with warnings: %s",
+			strings.Join(warnings, "; "))
+		return json.Marshal(protocol.SettingsValidationResponse{
+			Valid:   true,
+			Message: &msg,
+		})
+	}
+
+	logger.Warn("rejecting settings")
+	return kubewarden.RejectSettings(
+		kubewarden.Message(fmt.Sprintf("provided settings are not valid: %v", err)))
+}
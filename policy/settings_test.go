@@ -0,0 +1,577 @@
+package policy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	metav1 "github.com/kubewarden/k8s-objects/apimachinery/pkg/apis/meta/v1"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+func TestParsingSettingsWithAllValuesProvidedFromValidationReq(t *testing.T) {
+	request := `
+	{
+		"request": "doesn't matter here",
+		"settings": {
+			"allowedUnsafeSysctls": ["net.core.somaxconn"],
+			"forbiddenSysctls": ["kernel.shm_rmid_forced", "net.*"]
+		}
+	}
+	`
+	rawRequest := []byte(request)
+
+	settings, err := NewSettingsFromValidationReq(rawRequest)
+	if err != nil {
+		t.Errorf("Unexpected error %+v", err)
+	}
+
+	expected := []string{"net.core.somaxconn"}
+	for _, exp := range expected {
+		if !settings.AllowedUnsafeSysctls.Contains(exp) {
+			t.Errorf("Missing value %s", exp)
+		}
+	}
+
+	expected = []string{"kernel.shm_rmid_forced", "net.*"}
+	for _, exp := range expected {
+		if !settings.ForbiddenSysctls.Contains(exp) {
+			t.Errorf("Missing value %s", exp)
+		}
+	}
+}
+
+func TestParsingForbiddenSysctlsObjectForm(t *testing.T) {
+	payload := []byte(`{
+		"forbiddenSysctls": [
+			"kernel.shm_rmid_forced",
+			{"name": "net.*", "action": "warn", "message": "will be denied soon"}
+		]
+	}`)
+
+	settings, err := NewSettingsFromValidateSettingsPayload(payload)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	for _, exp := range []string{"kernel.shm_rmid_forced", "net.*"} {
+		if !settings.ForbiddenSysctls.Contains(exp) {
+			t.Errorf("Missing value %s", exp)
+		}
+	}
+
+	rule := settings.forbiddenSysctlRule("net.*")
+	if rule.Action != ActionWarn || rule.Message != "will be denied soon" {
+		t.Errorf("unexpected rule %+v", rule)
+	}
+	if settings.forbiddenSysctlRule("kernel.shm_rmid_forced").action() != ActionDeny {
+		t.Errorf("plain entries should deny")
+	}
+
+	// the settings must survive a round trip
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	roundTrip, err := NewSettingsFromValidateSettingsPayload(raw)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if roundTrip.forbiddenSysctlRule("net.*") != rule {
+		t.Errorf("got rule %+v after round trip, wanted %+v", roundTrip.forbiddenSysctlRule("net.*"), rule)
+	}
+	if !roundTrip.ForbiddenSysctls.Equal(settings.ForbiddenSysctls) {
+		t.Errorf("got %v after round trip, wanted %v", roundTrip.ForbiddenSysctls, settings.ForbiddenSysctls)
+	}
+}
+
+func TestParsingAllowedUnsafeSysctlsObjectForm(t *testing.T) {
+	payload := []byte(`{
+		"allowedUnsafeSysctls": [
+			"kernel.msgmax",
+			{"name": "net.core.somaxconn", "selector": {"matchLabels": {"app.kubernetes.io/component": "ingress"}}},
+			{"name": "kernel.msgmax", "selector": {"matchLabels": {"tier": "db"}}}
+		]
+	}`)
+
+	settings, err := NewSettingsFromValidateSettingsPayload(payload)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	if !settings.AllowedUnsafeSysctls.Equal(mapset.NewThreadUnsafeSet("kernel.msgmax", "net.core.somaxconn")) {
+		t.Errorf("unexpected allowedUnsafeSysctls %v", settings.AllowedUnsafeSysctls)
+	}
+	if _, scoped := settings.AllowedUnsafeSysctlRules["kernel.msgmax"]; scoped {
+		t.Errorf("kernel.msgmax is allowed without scope, it should not have rules")
+	}
+	if len(settings.AllowedUnsafeSysctlRules["net.core.somaxconn"]) != 1 {
+		t.Errorf("unexpected rules %v", settings.AllowedUnsafeSysctlRules)
+	}
+
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	expected := `{"allowedUnsafeSysctls":["kernel.msgmax",` +
+		`{"name":"net.core.somaxconn","selector":{"matchLabels":{"app.kubernetes.io/component":"ingress"}}}],`
+	if !strings.HasPrefix(string(raw), expected) {
+		t.Errorf("got %s, wanted it to start with %s", raw, expected)
+	}
+}
+
+func TestParsingRuleMetadata(t *testing.T) {
+	payload := []byte(`{
+		"allowedUnsafeSysctls": [
+			{"name": "kernel.msgmax", "selector": {"matchLabels": {"tier": "db"}}},
+			{"name": "kernel.msgmax", "id": "CR-1", "description": "message queues for the batch jobs"}
+		],
+		"forbiddenSysctls": [
+			{"name": "net.*", "id": "CR-2"}
+		]
+	}`)
+
+	settings, err := NewSettingsFromValidateSettingsPayload(payload)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	if settings.allowedUnsafeSysctlScoped("kernel.msgmax") {
+		t.Errorf("kernel.msgmax is allowed without scope")
+	}
+	w := workload{
+		metadata: &metav1.ObjectMeta{Namespace: "default"},
+		podSpec:  &corev1.PodSpec{},
+	}
+	rule, allowed := settings.allowingRule("kernel.msgmax", &w)
+	if !allowed || rule.ID != "CR-1" || rule.Description != "message queues for the batch jobs" {
+		t.Errorf("got rule %+v, allowed %v", rule, allowed)
+	}
+	if settings.forbiddenSysctlRule("net.*").ID != "CR-2" {
+		t.Errorf("got rule %+v", settings.forbiddenSysctlRule("net.*"))
+	}
+
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	expected := `{"allowedUnsafeSysctls":[` +
+		`{"name":"kernel.msgmax","id":"CR-1","description":"message queues for the batch jobs"}],` +
+		`"forbiddenSysctls":[{"name":"net.*","id":"CR-2"}],`
+	if !strings.HasPrefix(string(raw), expected) {
+		t.Errorf("got %s, wanted it to start with %s", raw, expected)
+	}
+}
+
+func TestParsingSettingsWithNoValueProvided(t *testing.T) {
+	request := `
+	{
+		"request": "doesn't matter here",
+		"settings": {
+		}
+	}
+	`
+	rawRequest := []byte(request)
+
+	settings, err := NewSettingsFromValidationReq(rawRequest)
+	if err != nil {
+		t.Errorf("Unexpected error %+v", err)
+	}
+
+	if settings.AllowedUnsafeSysctls.Cardinality() != 0 {
+		t.Errorf("Expected AllowedUnsafeSysctls to be empty")
+	}
+
+	if settings.ForbiddenSysctls.Cardinality() != 0 {
+		t.Errorf("Expected ForbiddenSysctls to be empty")
+	}
+}
+
+func TestSettingsAreValid(t *testing.T) {
+
+	for _, tcase := range []struct {
+		name      string
+		request   string
+		wantError bool
+		error     string
+	}{
+		{
+			name: "empty settings",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+				}
+			}
+			`,
+		},
+		{
+			name: "correct settings",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"allowedUnsafeSysctls": ["net.core.somaxconn"],
+					"forbiddenSysctls": ["kernel.shm_rmid_forced", "net.*"]
+				}
+			}
+			`,
+		},
+		{
+			name: "allowedUnsafeSysctls doesn't accept patterns with *",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"allowedUnsafeSysctls": ["net.*"],
+					"forbiddenSysctls": ["net.core.somaxconn"]
+				}
+			}
+			`,
+			wantError: true,
+			error:     "allowedUnsafeSysctls doesn't accept patterns with `*`",
+		},
+		{
+			name: "allowedUnsafeSysctls entries cannot be empty",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"allowedUnsafeSysctls": [""]
+				}
+			}
+			`,
+			wantError: true,
+			error:     "allowedUnsafeSysctls entries cannot be empty",
+		},
+		{
+			name: "forbiddenSysctls entries cannot be empty",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"forbiddenSysctls": [""]
+				}
+			}
+			`,
+			wantError: true,
+			error:     "forbiddenSysctls entries cannot be empty",
+		},
+		{
+			name: "globs need to be suffix",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"allowedUnsafeSysctls": ["net.core.somaxconn"],
+					"forbiddenSysctls": ["kernel.shm_rmid_forced", "net.*.foo"]
+				}
+			}
+			`,
+			wantError: true,
+			error:     "forbiddenSysctls only accepts patterns with `*` as suffix",
+		},
+		{
+			name: "sysctl in both fields",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"allowedUnsafeSysctls": ["net.core.somaxconn"],
+					"forbiddenSysctls": ["net.core.somaxconn"]
+				}
+			}
+			`,
+			wantError: true,
+			error:     "these sysctls cannot be allowed and forbidden at the same time: net.core.somaxconn",
+		},
+		{
+			name: "unknown forbiddenSysctls action",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"forbiddenSysctls": [{"name": "net.*", "action": "block"}]
+				}
+			}
+			`,
+			wantError: true,
+			error:     "forbiddenSysctls entry net.*: action must be one of `deny`, `warn` or `log`",
+		},
+		{
+			name: "discouragedSysctls globs need to be suffix",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"discouragedSysctls": [{"name": "net.*.tcp_tw_recycle", "reason": "removed"}]
+				}
+			}
+			`,
+			wantError: true,
+			error:     "discouragedSysctls only accepts patterns with `*` as suffix",
+		},
+		{
+			name: "break-glass without groups",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"breakGlass": {"enabled": true}
+				}
+			}
+			`,
+			wantError: true,
+			error:     "breakGlass.groups cannot be empty when break-glass is enabled",
+		},
+		{
+			name: "allowedUnsafeSysctls with invalid selector",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"allowedUnsafeSysctls": [
+						{"name": "net.core.somaxconn", "selector": {"matchExpressions": [{"key": "tier", "operator": "Exists", "values": ["a"]}]}}
+					]
+				}
+			}
+			`,
+			wantError: true,
+			error:     "allowedUnsafeSysctls entry net.core.somaxconn: selector: matchExpressions key tier: values must be empty when operator is Exists",
+		},
+		{
+			name: "allowedUnsafeSysctls with malformed service account",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"allowedUnsafeSysctls": [{"name": "net.core.somaxconn", "serviceAccounts": ["node-tuner"]}]
+				}
+			}
+			`,
+			wantError: true,
+			error:     "allowedUnsafeSysctls entry net.core.somaxconn: serviceAccounts entry \"node-tuner\" must be in the `namespace/name` form",
+		},
+		{
+			name: "malformed exemptNamespaces glob",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"exemptNamespaces": ["kube-system", "cilium-["]
+				}
+			}
+			`,
+			wantError: true,
+			error:     "exemptNamespaces entry \"cilium-[\" is not a valid namespace or glob",
+		},
+		{
+			name: "unknown enforcementMode",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"enforcementMode": "audit"
+				}
+			}
+			`,
+			wantError: true,
+			error:     "enforcementMode must be either `deny` or `warn`",
+		},
+		{
+			name: "rules with ids",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"allowedUnsafeSysctls": [{"name": "net.core.somaxconn", "id": "CR-1"}],
+					"forbiddenSysctls": [{"name": "net.*", "id": "CR-2"}],
+					"discouragedSysctls": [{"name": "kernel.msgmax", "id": "CR-3"}]
+				}
+			}
+			`,
+		},
+		{
+			name: "rule id used more than once",
+			request: `
+			{
+				"request": "doesn't matter here",
+				"settings": {
+					"allowedUnsafeSysctls": [{"name": "net.core.somaxconn", "id": "CR-1"}],
+					"discouragedSysctls": [{"name": "kernel.msgmax", "id": "CR-1"}]
+				}
+			}
+			`,
+			wantError: true,
+			error:     "rule id CR-1 is used more than once",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			rawRequest := []byte(tcase.request)
+
+			settings, _ := NewSettingsFromValidationReq(rawRequest)
+			valid, err := settings.Valid()
+
+			if err != nil {
+				if !tcase.wantError {
+					t.Errorf("on test %q, got unexpected error '%v'", tcase.name, err)
+				} else {
+					if tcase.error != err.Error() {
+						t.Errorf("on test %q, got error '%v', wanted error '%v'", tcase.name, err, tcase.error)
+					}
+				}
+			}
+			if tcase.wantError && valid {
+				t.Errorf("on test %q, settings are valid and we got unexpected error '%v'",
+					tcase.name, err)
+			}
+		})
+	}
+}
+
+func TestSettingsWarnings(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		settings string
+		warnings []string
+	}{
+		{
+			name:     "no warnings",
+			settings: `{"allowedUnsafeSysctls": ["net.core.somaxconn"], "forbiddenSysctls": ["kernel.shm_rmid_forced", "net.ipv4.*"]}`,
+			warnings: []string{},
+		},
+		{
+			name:     "allowed sysctl already on safe list",
+			settings: `{"allowedUnsafeSysctls": ["net.ipv4.tcp_syncookies"]}`,
+			warnings: []string{
+				"allowedUnsafeSysctls entry net.ipv4.tcp_syncookies is already on the safe list",
+			},
+		},
+		{
+			name:     "allowed sysctl carved out of forbidden pattern",
+			settings: `{"allowedUnsafeSysctls": ["net.core.somaxconn"], "forbiddenSysctls": ["net.core.*"]}`,
+			warnings: []string{},
+		},
+		{
+			name:     "allowed sysctl shadows the forbidden pattern meant for it",
+			settings: `{"allowedUnsafeSysctls": ["net.core.somaxconn"], "forbiddenSysctls": ["net.core.somaxconn*"]}`,
+			warnings: []string{
+				"forbiddenSysctls pattern net.core.somaxconn* never applies to net.core.somaxconn, " +
+					"allowedUnsafeSysctls has precedence",
+			},
+		},
+		{
+			name:     "network-tuning preset carved out of forbidden pattern",
+			settings: `{"preset": "network-tuning", "forbiddenSysctls": ["net.*"]}`,
+			warnings: []string{},
+		},
+		{
+			name:     "redundant forbidden entries",
+			settings: `{"forbiddenSysctls": ["net.*", "net.core.*", "net.ipv4.tcp_syncookies", "kernel.shm_rmid_forced"]}`,
+			warnings: []string{
+				"forbiddenSysctls entry net.core.* is redundant, it is already covered by net.*",
+				"forbiddenSysctls entry net.ipv4.tcp_syncookies is redundant, it is already covered by net.*",
+			},
+		},
+		{
+			name:     "forbid everything with allowed sysctls",
+			settings: `{"allowedUnsafeSysctls": ["net.core.somaxconn"], "forbiddenSysctls": ["*"]}`,
+			warnings: []string{
+				"forbiddenSysctls contains `*`: only the sysctls listed in allowedUnsafeSysctls can be used, including the ones on the safe list",
+			},
+		},
+		{
+			name:     "forbid everything with allowed safe sysctl",
+			settings: `{"allowedUnsafeSysctls": ["net.ipv4.tcp_syncookies"], "forbiddenSysctls": ["*"]}`,
+			warnings: []string{
+				"forbiddenSysctls contains `*`: only the sysctls listed in allowedUnsafeSysctls can be used, including the ones on the safe list",
+			},
+		},
+		{
+			name:     "allowed safe sysctl carved out of forbidden pattern",
+			settings: `{"allowedUnsafeSysctls": ["net.ipv4.tcp_syncookies"], "forbiddenSysctls": ["net.ipv4.*"]}`,
+			warnings: []string{},
+		},
+		{
+			name:     "strict preset with allowed safe sysctl",
+			settings: `{"preset": "strict", "allowedUnsafeSysctls": ["net.ipv4.tcp_syncookies"]}`,
+			warnings: []string{},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(tcase.settings))
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			warnings := settings.Warnings()
+			if len(warnings) != len(tcase.warnings) {
+				t.Fatalf("got warnings %v, wanted %v", warnings, tcase.warnings)
+			}
+			for i := range warnings {
+				if warnings[i] != tcase.warnings[i] {
+					t.Errorf("got warning '%s', wanted '%s'", warnings[i], tcase.warnings[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSettingsReportsWarnings(t *testing.T) {
+	payload := []byte(`{"allowedUnsafeSysctls": ["net.ipv4.tcp_syncookies"]}`)
+
+	responsePayload, err := ValidateSettings(payload)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	var response kubewarden_protocol.SettingsValidationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	if !response.Valid {
+		t.Errorf("settings with warnings should be valid")
+	}
+
+	expected := "provided settings are valid, with warnings: " +
+		"allowedUnsafeSysctls entry net.ipv4.tcp_syncookies is already on the safe list"
+	if response.Message == nil || *response.Message != expected {
+		t.Errorf("got message %v, wanted '%s'", response.Message, expected)
+	}
+}
+
+func TestZeroValueSettings(t *testing.T) {
+	settings := Settings{}
+
+	if valid, err := settings.Valid(); !valid {
+		t.Errorf("unexpected error %+v", err)
+	}
+	if warnings := settings.Warnings(); len(warnings) != 0 {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+	if _, _, _, err := settings.ExportValidatingAdmissionPolicy("sysctls"); err != nil {
+		t.Errorf("unexpected error %+v", err)
+	}
+
+	for _, tcase := range []struct {
+		sysctl   string
+		accepted bool
+	}{
+		{sysctl: "kernel.shm_rmid_forced", accepted: true},
+		{sysctl: "net.core.somaxconn", accepted: false},
+	} {
+		decision := Evaluate(&settings, kubewarden_protocol.KubernetesAdmissionRequest{
+			Kind:      kubewarden_protocol.GroupVersionKind{Kind: "Pod"},
+			Namespace: "default",
+			Operation: "CREATE",
+			Object: json.RawMessage(`{"metadata": {"name": "hello"}, "spec": {"securityContext": ` +
+				`{"sysctls": [{"name": "` + tcase.sysctl + `", "value": "1"}]}}}`),
+		})
+		if decision.Accepted != tcase.accepted {
+			t.Errorf("%s: got accepted %t, wanted %t: %s", tcase.sysctl, decision.Accepted, tcase.accepted, decision.Message)
+		}
+	}
+}
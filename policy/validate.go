@@ -0,0 +1,606 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	onelog "github.com/francoispqt/onelog"
+	"github.com/kubewarden/gjson"
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	metav1 "github.com/kubewarden/k8s-objects/apimachinery/pkg/apis/meta/v1"
+	kubewarden "github.com/kubewarden/policy-sdk-go"
+	"github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+// CreateSafeSysctlsSet returns a set with the known safe sysctls.
+//
+// A sysctl is called safe iff:
+// - it is namespaced in the container or the pod
+// - it is isolated, i.e. has no influence on any other pod on the same node.
+//
+// A (possibly not up-to-date) list of known safe sysctls can be found at:
+// https://kubernetes.io/docs/concepts/security/pod-security-standards/#baseline
+func CreateSafeSysctlsSet() (safeSysctls mapset.Set[string]) {
+	safeSysctls = mapset.NewThreadUnsafeSet[string]()
+	safeSysctls.Add("kernel.shm_rmid_forced")
+	safeSysctls.Add("net.ipv4.ip_local_port_range")
+	safeSysctls.Add("net.ipv4.tcp_syncookies")
+	safeSysctls.Add("net.ipv4.ping_group_range")
+	return safeSysctls
+}
+
+// Decision is the outcome of the evaluation of an admission request.
+type Decision struct {
+	Accepted bool
+	// The rejection message, including the decision trace when explain is
+	// enabled
+	Message string
+	// The rejection code, kubewarden.NoCode when not set
+	Code kubewarden.Code
+	// The admission warnings of the accepted requests, including the
+	// decision trace when explain is enabled
+	Warnings []string
+	// The violations causing the rejection, in the structured rejection
+	// format. Empty when the request is rejected because it's not well
+	// formed
+	Violations []ViolationReport
+}
+
+// rejected returns the decision rejecting a request that is not well formed.
+func rejected(message string, code kubewarden.Code) Decision {
+	return Decision{Message: message, Code: code}
+}
+
+// Validate is the `validate` entrypoint of the policy, it evaluates the
+// admission request of the payload against the settings the payload holds.
+func Validate(payload []byte) ([]byte, error) {
+	settings, err := NewSettingsFromValidationReq(payload)
+	if err != nil {
+		return kubewarden.RejectRequest(
+			kubewarden.Message(err.Error()),
+			kubewarden.Code(400))
+	}
+
+	validationRequest := protocol.ValidationRequest{}
+	if err := json.Unmarshal(payload, &validationRequest); err != nil {
+		return kubewarden.RejectRequest(
+			kubewarden.Message(fmt.Sprintf("cannot parse validation request: %v", err)),
+			kubewarden.Code(400))
+	}
+
+	decision := Evaluate(&settings, validationRequest.Request)
+	if !decision.Accepted {
+		return kubewarden.RejectRequest(kubewarden.Message(decision.Message), decision.Code)
+	}
+	if len(decision.Warnings) != 0 {
+		return acceptRequestWithWarnings(decision.Warnings)
+	}
+	return kubewarden.AcceptRequest()
+}
+
+// Evaluate evaluates the admission request against the settings. The
+// request can be about a Pod, or about any of the workload kinds with a Pod
+// template listed by podTemplatePaths.
+func Evaluate(settings *Settings, request protocol.KubernetesAdmissionRequest) Decision {
+	logger.Info("validating request")
+
+	if settings.namespaceExempted(request.Namespace) {
+		logger.DebugWithFields("namespace is exempted, accepting", func(e onelog.Entry) {
+			e.String("name", request.Name)
+			e.String("namespace", request.Namespace)
+		})
+		if settings.Explain {
+			return Decision{
+				Accepted: true,
+				Warnings: []string{fmt.Sprintf("decision trace: namespace %s is exempted", request.Namespace)},
+			}
+		}
+		return Decision{Accepted: true}
+	}
+
+	if request.Operation == "DELETE" {
+		// DELETE requests have no object, there's nothing to validate
+		return Decision{Accepted: true}
+	}
+
+	switch request.SubResource {
+	case "status", "binding", "eviction":
+		// these subresources cannot change the sysctls of the pod
+		return Decision{Accepted: true}
+	case "ephemeralcontainers":
+		return validateEphemeralContainers(request)
+	}
+
+	metadata, podSpec, err := extractPodTemplate(request.Kind.Kind, request.Object)
+	if err != nil {
+		return rejected(err.Error(), 400)
+	}
+
+	if podSpec.SecurityContext == nil || len(podSpec.SecurityContext.Sysctls) == 0 {
+		// Pod specifies no sysctls, accepting
+		return Decision{Accepted: true}
+	}
+	sysctls := podSpec.SecurityContext.Sysctls
+
+	if metadata.Namespace == "" {
+		metadata.Namespace = request.Namespace
+	}
+	logger.DebugWithFields("validating pod object", func(e onelog.Entry) {
+		e.String("name", metadata.Name)
+		e.String("namespace", metadata.Namespace)
+	})
+
+	w := workload{
+		metadata: &metadata,
+		podSpec:  &podSpec,
+	}
+
+	breakGlass, err := breakGlassJustification(settings, &request, &metadata)
+	if err != nil {
+		return rejected(err.Error(), kubewarden.NoCode)
+	}
+
+	// sysctls already set by the old object, keyed by name. These are not
+	// evaluated again when grandfathering is enabled.
+	existingSysctls := map[string]string{}
+	if settings.GrandfatherExisting && request.Operation == "UPDATE" {
+		_, oldPodSpec, err := extractPodTemplate(request.Kind.Kind, request.OldObject)
+		if err == nil && oldPodSpec.SecurityContext != nil {
+			for _, sysctl := range oldPodSpec.SecurityContext.Sysctls {
+				if sysctl != nil && sysctl.Name != nil {
+					existingSysctls[*sysctl.Name] = sysctlValue(sysctl)
+				}
+			}
+		}
+	}
+
+	explain := explainEnabled(settings, &metadata)
+	trace := decisionTrace{}
+
+	denied := []*violation{}
+	warnings := []string{}
+	for _, sysctl := range sysctls {
+		name := *sysctl.Name
+
+		var t *sysctlTrace
+		if explain {
+			t = explainSysctl(settings, sysctl, &w)
+			trace = append(trace, t)
+		}
+		t.decide("accepted")
+
+		if oldValue, found := existingSysctls[name]; found && oldValue == sysctlValue(sysctl) {
+			t.decide("accepted, grandfathered from the old object")
+			logger.InfoWithFields("sysctl grandfathered", func(e onelog.Entry) {
+				e.String("sysctl", name)
+				e.String("name", metadata.Name)
+				e.String("namespace", metadata.Namespace)
+			})
+			continue
+		}
+
+		violations := validateSysctl(settings, sysctl, &w, false)
+		if len(violations) != 0 {
+			if exception := settings.exceptionFor(name, &metadata); exception != nil {
+				logger.InfoWithFields("sysctl allowed by exception", func(e onelog.Entry) {
+					e.String("sysctl", name)
+					e.String("name", metadata.Name)
+					e.String("namespace", metadata.Namespace)
+					e.String("reason", exception.Reason)
+					e.String("validUntil", exception.ValidUntil)
+					e.String("exceptionId", exception.ID)
+				})
+				t.decide("accepted by the exception valid until %s", exception.ValidUntil)
+				continue
+			}
+		}
+		if bypassed := forbiddingViolation(violations); breakGlass != "" && bypassed != nil {
+			// the sysctl is forbidden, break-glass allows to use it as long
+			// as it's on the safe list or allowed
+			logger.WarnWithFields("forbiddenSysctls bypassed with break-glass", func(e onelog.Entry) {
+				e.String("sysctl", name)
+				e.String("name", metadata.Name)
+				e.String("namespace", metadata.Namespace)
+				e.String("uid", request.Uid)
+				e.String("user", request.UserInfo.Username)
+				e.String("justification", breakGlass)
+				e.String("expires", metadata.Annotations[BreakGlassExpiresAnnotation])
+				e.String("rule", bypassed.rule.Name)
+				e.String("ruleId", bypassed.rule.ID)
+			})
+			t.decide("accepted with break-glass: %s", breakGlass)
+			violations = validateSysctl(settings, sysctl, &w, true)
+		}
+
+		for _, v := range violations {
+			action := v.action()
+			if action == ActionDeny && settings.EnforcementMode == EnforcementModeWarn {
+				action = ActionWarn
+			}
+
+			logViolation := func(e onelog.Entry) {
+				e.String("sysctl", name)
+				e.String("name", metadata.Name)
+				e.String("namespace", metadata.Namespace)
+				e.String("uid", request.Uid)
+				e.String("violation", v.Error())
+				e.String("rule", v.rule.Name)
+				e.String("ruleId", v.rule.ID)
+			}
+			switch action {
+			case ActionWarn:
+				logger.WarnWithFields("sysctl violates the policy", logViolation)
+				warnings = append(warnings, v.Error())
+				t.decide("accepted with a warning: %s", v.Error())
+			case ActionLog:
+				logger.InfoWithFields("sysctl violates the policy", logViolation)
+				t.decide("accepted, the violation is logged: %s", v.Error())
+			default:
+				denied = append(denied, v)
+				t.decide("denied: %s", v.Error())
+			}
+		}
+		if len(denied) != 0 && denied[len(denied)-1].sysctl == name {
+			// the other sysctls are still evaluated, all the denied ones
+			// are reported by the structured rejection
+			continue
+		}
+
+		if discouraged, found := settings.discouragedSysctl(name); found {
+			warning := fmt.Sprintf("sysctl %s is discouraged%s", name, discouraged.reference())
+			if discouraged.Reason != "" {
+				warning = fmt.Sprintf("%s: %s", warning, discouraged.Reason)
+			}
+			warnings = append(warnings, warning)
+		}
+	}
+
+	explanation := ""
+	if explain {
+		explanation = trace.String()
+		logger.DebugWithFields("decision trace", func(e onelog.Entry) {
+			e.String("name", metadata.Name)
+			e.String("namespace", metadata.Namespace)
+			e.String("trace", explanation)
+		})
+	}
+
+	if len(denied) != 0 {
+		logger.DebugWithFields("rejecting pod object", func(e onelog.Entry) {
+			e.String("name", metadata.Name)
+			e.String("namespace", metadata.Namespace)
+		})
+
+		return Decision{
+			Message:    rejectionMessage(settings, denied, &metadata, explanation),
+			Code:       rejectionCode(settings),
+			Violations: newStructuredRejection(denied).Violations,
+		}
+	}
+
+	// the API server doesn't show the message of the accepted requests, the
+	// decision trace is returned as admission warnings
+	return Decision{
+		Accepted: true,
+		Warnings: append(warnings, trace.warnings()...),
+	}
+}
+
+// validationResponse is the SDK ValidationResponse, extended with the
+// admission warnings to be shown to the user.
+type validationResponse struct {
+	protocol.ValidationResponse
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// acceptRequestWithWarnings accepts the incoming request, the given warnings
+// are returned to the user as admission warnings.
+func acceptRequestWithWarnings(warnings []string) ([]byte, error) {
+	response := validationResponse{
+		ValidationResponse: protocol.ValidationResponse{
+			Accepted: true,
+		},
+		Warnings: warnings,
+	}
+
+	return json.Marshal(response)
+}
+
+// workload is the object being validated. The allowedUnsafeSysctls rules can
+// be scoped to its properties.
+type workload struct {
+	metadata *metav1.ObjectMeta
+	podSpec  *corev1.PodSpec
+}
+
+// serviceAccount returns the service account the Pod runs as, in the
+// `namespace/name` form.
+func (w *workload) serviceAccount() string {
+	name := w.podSpec.ServiceAccountName
+	if name == "" {
+		// deprecated alias of serviceAccountName
+		name = w.podSpec.ServiceAccount
+	}
+	if name == "" {
+		name = "default"
+	}
+	return w.metadata.Namespace + "/" + name
+}
+
+// violation describes why a sysctl cannot be used.
+type violation struct {
+	sysctl  string
+	value   string
+	message string
+	// One of the Violation* constants
+	kind string
+	// The index of the rule in forbiddenSysctls, or in rules when the
+	// rules list is used. -1 when there's no rule
+	ruleIndex int
+	// The forbiddenSysctls rule that has been violated. Its name is empty
+	// when the sysctl is neither on the safe list nor allowed.
+	rule ForbiddenSysctl
+}
+
+func (v *violation) Error() string {
+	message := v.message + v.rule.reference()
+	if v.rule.Message != "" {
+		return fmt.Sprintf("%s: %s", message, v.rule.Message)
+	}
+	return message
+}
+
+// action returns the action to be taken for the violation.
+func (v *violation) action() string {
+	return v.rule.action()
+}
+
+// validateSysctl returns the violations of the settings caused by the given
+// sysctl, none when the sysctl can be used. The allowedUnsafeSysctls and
+// forbiddenSysctls are evaluated through the rules list they compile into.
+//
+// When the sysctl is forbidden by a rule whose action is not `deny`, the
+// sysctl must still be either on the safe list or allowed. The same applies
+// when breakGlass is set: the forbidding rules are bypassed, the sysctl must
+// still be either on the safe list or allowed.
+func validateSysctl(settings *Settings, entry *corev1.Sysctl, w *workload, breakGlass bool) []*violation {
+	if len(settings.Rules) != 0 {
+		return validateSysctlWithRules(settings, entry, w, breakGlass)
+	}
+
+	sysctl := *entry.Name
+	value := sysctlValue(entry)
+	violations := []*violation{}
+
+	rules, sources := settings.compiledRules()
+	if index := firstMatchingRule(rules, sysctl, w, breakGlass); index >= 0 {
+		if rules[index].Action == ActionAllow {
+			return violations
+		}
+		source := sources[index]
+		kind := ViolationForbiddenExact
+		if source.entry.Name != sysctl {
+			kind = ViolationForbiddenPattern
+		}
+		violations = append(violations, &violation{
+			sysctl:    sysctl,
+			value:     value,
+			message:   fmt.Sprintf("sysctl %s is on the forbidden list", sysctl),
+			kind:      kind,
+			rule:      source.entry,
+			ruleIndex: source.index,
+		})
+		if !source.notAllowed {
+			return violations
+		}
+	}
+
+	// if sysctl is not on the safe list nor an exception, it is forbidden:
+	if !CreateSafeSysctlsSet().Contains(sysctl) {
+		message := fmt.Sprintf("sysctl %s is not on safe list, nor is in the allowedUnsafeSysctls list",
+			sysctl)
+		if settings.allowedUnsafeSysctlsSet().Contains(sysctl) {
+			message = fmt.Sprintf("sysctl %s is in the allowedUnsafeSysctls list, but not for this workload",
+				sysctl)
+		}
+		violations = append(violations, &violation{
+			sysctl:    sysctl,
+			value:     value,
+			message:   message,
+			kind:      ViolationNotAllowed,
+			ruleIndex: -1,
+		})
+	}
+
+	return violations
+}
+
+// forbiddingViolation returns the first of the violations caused by a
+// forbidding rule, the ones break-glass can bypass. nil when there's none.
+func forbiddingViolation(violations []*violation) *violation {
+	for _, v := range violations {
+		if v.kind != ViolationNotAllowed {
+			return v
+		}
+	}
+	return nil
+}
+
+// podTemplatePaths are the paths of the Pod template within the objects of
+// the supported kinds, the Pods being their own template.
+var podTemplatePaths = map[string]string{
+	"Pod":                   "",
+	"Deployment":            "spec.template",
+	"ReplicaSet":            "spec.template",
+	"StatefulSet":           "spec.template",
+	"DaemonSet":             "spec.template",
+	"ReplicationController": "spec.template",
+	"Job":                   "spec.template",
+	"CronJob":               "spec.jobTemplate.spec.template",
+}
+
+// extractPodTemplate returns the metadata and the spec of the Pod, or of the
+// Pod template of the workload, held by the object. The labels and the
+// annotations are the ones of the template, the name and the namespace the
+// ones of the object. An empty PodSpec is returned when the object doesn't
+// set it. The sysctls are checked before decoding the spec, the malformed
+// ones are reported with their path.
+func extractPodTemplate(kind string, object json.RawMessage) (metav1.ObjectMeta, corev1.PodSpec, error) {
+	templatePath, found := podTemplatePaths[kind]
+	if !found {
+		return metav1.ObjectMeta{}, corev1.PodSpec{}, fmt.Errorf(
+			"cannot parse object: object should be one of these kinds: " +
+				"Deployment, ReplicaSet, StatefulSet, DaemonSet, ReplicationController, Job, CronJob, Pod")
+	}
+	metadataPath, specPath := "metadata", "spec"
+	if templatePath != "" {
+		metadataPath, specPath = templatePath+".metadata", templatePath+".spec"
+	}
+
+	metadata := extractObjectMeta(object)
+	if templatePath != "" {
+		templateMetadata := metav1.ObjectMeta{}
+		if raw := gjson.GetBytes(object, metadataPath); raw.IsObject() {
+			if err := json.Unmarshal([]byte(raw.Raw), &templateMetadata); err != nil {
+				return metav1.ObjectMeta{}, corev1.PodSpec{}, fmt.Errorf("cannot parse object: %w", err)
+			}
+		}
+		metadata.Labels = templateMetadata.Labels
+		metadata.Annotations = templateMetadata.Annotations
+	}
+
+	podSpec := corev1.PodSpec{}
+	spec := gjson.GetBytes(object, specPath)
+	if !spec.Exists() || spec.Type == gjson.Null {
+		return metadata, podSpec, nil
+	}
+	if !spec.IsObject() {
+		return metav1.ObjectMeta{}, corev1.PodSpec{}, fmt.Errorf("%s must be an object", specPath)
+	}
+	sysctlsPath := specPath + ".securityContext.sysctls"
+	if err := checkSysctlsStructure(sysctlsPath, spec.Get("securityContext.sysctls")); err != nil {
+		return metav1.ObjectMeta{}, corev1.PodSpec{}, err
+	}
+
+	if err := json.Unmarshal([]byte(spec.Raw), &podSpec); err != nil {
+		return metav1.ObjectMeta{}, corev1.PodSpec{}, fmt.Errorf("cannot parse object: %w", err)
+	}
+	return metadata, podSpec, nil
+}
+
+// checkSysctlsStructure ensures each entry of the `sysctls` field, found at
+// the given path, is an object with a non empty `name`, and with a `value`
+// that is a string when set. The same sysctl cannot be set more than once
+// with different values.
+func checkSysctlsStructure(field string, data gjson.Result) error {
+	if !data.Exists() || data.Type == gjson.Null {
+		return nil
+	}
+	if !data.IsArray() {
+		return fmt.Errorf("%s must be a list", field)
+	}
+
+	values := map[string]string{}
+	for index, entry := range data.Array() {
+		if !entry.IsObject() {
+			return fmt.Errorf("%s[%d] must be an object", field, index)
+		}
+		name := entry.Get("name")
+		switch {
+		case !name.Exists():
+			return fmt.Errorf("%s[%d].name is required", field, index)
+		case name.Type != gjson.String:
+			return fmt.Errorf("%s[%d].name must be a string", field, index)
+		case name.String() == "":
+			return fmt.Errorf("%s[%d].name cannot be empty", field, index)
+		}
+		value := entry.Get("value")
+		if value.Exists() && value.Type != gjson.String {
+			return fmt.Errorf("%s[%d].value must be a string", field, index)
+		}
+
+		if previous, found := values[name.String()]; found && previous != value.String() {
+			return fmt.Errorf("sysctl %s is set more than once with different values", name.String())
+		}
+		values[name.String()] = value.String()
+	}
+
+	return nil
+}
+
+// sysctlValue returns the value of the sysctl, or an empty string when it's
+// not set.
+func sysctlValue(sysctl *corev1.Sysctl) string {
+	if sysctl.Value == nil {
+		return ""
+	}
+	return *sysctl.Value
+}
+
+// extractObjectMeta returns the metadata of the given object. An empty
+// ObjectMeta is returned when the object doesn't have any.
+func extractObjectMeta(object json.RawMessage) metav1.ObjectMeta {
+	obj := struct {
+		Metadata *metav1.ObjectMeta `json:"metadata"`
+	}{}
+	if err := json.Unmarshal(object, &obj); err != nil || obj.Metadata == nil {
+		return metav1.ObjectMeta{}
+	}
+	return *obj.Metadata
+}
+
+// validateEphemeralContainers handles UPDATE requests against the
+// `ephemeralcontainers` subresource of a Pod.
+//
+// Such a request can only add ephemeral containers, the rest of the Pod,
+// including its sysctls, cannot be changed. Only the newly added ephemeral
+// containers are evaluated. Sysctls are set at the Pod level, the
+// securityContext of a container cannot set them, hence the new ephemeral
+// containers are always accepted.
+func validateEphemeralContainers(request protocol.KubernetesAdmissionRequest) Decision {
+	pod := corev1.Pod{}
+	if err := json.Unmarshal(request.Object, &pod); err != nil {
+		return rejected(fmt.Sprintf("cannot parse object: %v", err), 400)
+	}
+	oldPod := corev1.Pod{}
+	if len(request.OldObject) != 0 {
+		if err := json.Unmarshal(request.OldObject, &oldPod); err != nil {
+			return rejected(fmt.Sprintf("cannot parse old object: %v", err), 400)
+		}
+	}
+
+	for _, container := range newEphemeralContainers(&oldPod, &pod) {
+		logger.DebugWithFields("validating new ephemeral container", func(e onelog.Entry) {
+			e.String("container", *container.Name)
+			e.String("name", request.Name)
+			e.String("namespace", request.Namespace)
+		})
+	}
+
+	return Decision{Accepted: true}
+}
+
+// newEphemeralContainers returns the ephemeral containers of pod that are not
+// part of oldPod.
+func newEphemeralContainers(oldPod, pod *corev1.Pod) []*corev1.EphemeralContainer {
+	existing := mapset.NewThreadUnsafeSet[string]()
+	if oldPod.Spec != nil {
+		for _, container := range oldPod.Spec.EphemeralContainers {
+			if container != nil && container.Name != nil {
+				existing.Add(*container.Name)
+			}
+		}
+	}
+
+	added := []*corev1.EphemeralContainer{}
+	if pod.Spec == nil {
+		return added
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		if container != nil && container.Name != nil && !existing.Contains(*container.Name) {
+			added = append(added, container)
+		}
+	}
+	return added
+}
@@ -0,0 +1,740 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+func TestApproval(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		testData string
+		settings Settings
+	}{
+		{
+			name:     "empty settings allows safe sysctls",
+			testData: "../test_data/request-pod-safe-sysctls.json",
+			settings: Settings{},
+		},
+		{
+			name:     "pod without sysctl always allowed",
+			testData: "../test_data/request-pod-no-sysctl.json",
+			settings: Settings{},
+		},
+		{
+			name:     "pod with allowedUnsafe sysctl",
+			testData: "../test_data/request-pod-somaxconn.json",
+			settings: Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet("net.core.somaxconn", "bar"),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("net.*"),
+			},
+		},
+		{
+			name:     "update with grandfathered sysctl",
+			testData: "../test_data/request-pod-somaxconn-update.json",
+			settings: Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("net.*"),
+				GrandfatherExisting:  true,
+			},
+		},
+		{
+			name:     "exempt namespace",
+			testData: "../test_data/request-pod-somaxconn.json",
+			settings: Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("*"),
+				ExemptNamespaces:     []string{"kube-system", "def*"},
+			},
+		},
+		{
+			name:     "delete is always allowed",
+			testData: "../test_data/request-pod-somaxconn-delete.json",
+			settings: Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("*"),
+			},
+		},
+		{
+			name:     "status update is always allowed",
+			testData: "../test_data/request-pod-somaxconn-status.json",
+			settings: Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("*"),
+			},
+		},
+		{
+			name:     "adding an ephemeral container does not evaluate pod sysctls",
+			testData: "../test_data/request-pod-somaxconn-ephemeral.json",
+			settings: Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("*"),
+			},
+		},
+		{
+			name:     "adding an ephemeral container to a pod with grandfathered sysctls",
+			testData: "../test_data/request-pod-somaxconn-ephemeral.json",
+			settings: Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("net.*"),
+				GrandfatherExisting:  true,
+			},
+		},
+	} {
+		payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+			tcase.testData,
+			&tcase.settings)
+		if err != nil {
+			t.Errorf("on test %q, got unexpected error '%+v'", tcase.name, err)
+		}
+
+		responsePayload, err := Validate(payload)
+		if err != nil {
+			t.Errorf("on test %q, got unexpected error '%+v'", tcase.name, err)
+		}
+
+		var response kubewarden_protocol.ValidationResponse
+		if err := json.Unmarshal(responsePayload, &response); err != nil {
+			t.Errorf("on test %q, got unexpected error '%+v'", tcase.name, err)
+		}
+
+		if response.Accepted != true {
+			t.Errorf("on test %q, got unexpected rejection", tcase.name)
+		}
+	}
+}
+
+func TestRejection(t *testing.T) {
+
+	for _, tcase := range []struct {
+		name     string
+		testData string
+		settings Settings
+		error    string
+	}{
+		{
+			name:     "empty settings reject non safe sysctls",
+			testData: "../test_data/request-pod-somaxconn.json",
+			settings: Settings{},
+			error:    "sysctl net.core.somaxconn is not on safe list, nor is in the allowedUnsafeSysctls list",
+		},
+		{
+			name:     "all sysctls forbidden",
+			testData: "../test_data/request-pod-somaxconn.json",
+			settings: Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("*"),
+			},
+			error: "sysctl net.core.somaxconn is on the forbidden list",
+		},
+		{
+			name:     "net.* sysctls forbidden",
+			testData: "../test_data/request-pod-somaxconn.json",
+			settings: Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("net.*"),
+			},
+			error: "sysctl net.core.somaxconn is on the forbidden list",
+		},
+		{
+			name:     "deployment with forbidden sysctl",
+			testData: "../test_data/request-deployment-somaxconn.json",
+			settings: Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("net.*"),
+			},
+			error: "sysctl net.core.somaxconn is on the forbidden list",
+		},
+		{
+			name:     "namespace not exempted",
+			testData: "../test_data/request-pod-somaxconn.json",
+			settings: Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("net.*"),
+				ExemptNamespaces:     []string{"kube-system", "cilium-*"},
+			},
+			error: "sysctl net.core.somaxconn is on the forbidden list",
+		},
+		{
+			name:     "update without grandfathering",
+			testData: "../test_data/request-pod-somaxconn-update.json",
+			settings: Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("net.*"),
+			},
+			error: "sysctl net.core.somaxconn is on the forbidden list",
+		},
+		{
+			name:     "update changing the value of a grandfathered sysctl",
+			testData: "../test_data/request-pod-somaxconn-update-changed.json",
+			settings: Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet("net.*"),
+				GrandfatherExisting:  true,
+			},
+			error: "sysctl net.core.somaxconn is on the forbidden list",
+		},
+	} {
+		payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+			tcase.testData,
+			&tcase.settings)
+		if err != nil {
+			t.Errorf("on test %q, got unexpected error '%+v'", tcase.name, err)
+		}
+
+		responsePayload, err := Validate(payload)
+		if err != nil {
+			t.Errorf("on test %q, got unexpected error '%+v'", tcase.name, err)
+		}
+
+		var response kubewarden_protocol.ValidationResponse
+		if err := json.Unmarshal(responsePayload, &response); err != nil {
+			t.Errorf("on test %q, got unexpected error '%+v'", tcase.name, err)
+		}
+
+		if response.Accepted != false {
+			t.Errorf("on test %q, got unexpected approval", tcase.name)
+		}
+
+		if *response.Message != tcase.error {
+			t.Errorf("on test %q, got '%s' instead of '%s'",
+				tcase.name, *response.Message, tcase.error)
+		}
+	}
+
+}
+
+func TestMalformedSysctls(t *testing.T) {
+	for _, tcase := range []struct {
+		name    string
+		sysctls string
+		error   string
+	}{
+		{
+			name:    "sysctls is not a list",
+			sysctls: `{"name": "net.core.somaxconn", "value": "1024"}`,
+			error:   "spec.securityContext.sysctls must be a list",
+		},
+		{
+			name:    "entry is not an object",
+			sysctls: `["net.core.somaxconn"]`,
+			error:   "spec.securityContext.sysctls[0] must be an object",
+		},
+		{
+			name:    "entry is null",
+			sysctls: `[null]`,
+			error:   "spec.securityContext.sysctls[0] must be an object",
+		},
+		{
+			name:    "missing name",
+			sysctls: `[{"name": "kernel.shm_rmid_forced", "value": "0"}, {"value": "1024"}]`,
+			error:   "spec.securityContext.sysctls[1].name is required",
+		},
+		{
+			name:    "name is not a string",
+			sysctls: `[{"name": 42, "value": "1024"}]`,
+			error:   "spec.securityContext.sysctls[0].name must be a string",
+		},
+		{
+			name:    "empty name",
+			sysctls: `[{"name": "", "value": "1024"}]`,
+			error:   "spec.securityContext.sysctls[0].name cannot be empty",
+		},
+		{
+			name:    "value is not a string",
+			sysctls: `[{"name": "kernel.shm_rmid_forced", "value": 0}]`,
+			error:   "spec.securityContext.sysctls[0].value must be a string",
+		},
+		{
+			name:    "duplicate names with conflicting values",
+			sysctls: `[{"name": "kernel.shm_rmid_forced", "value": "0"}, {"name": "kernel.shm_rmid_forced", "value": "1"}]`,
+			error:   "sysctl kernel.shm_rmid_forced is set more than once with different values",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			object := json.RawMessage(`{
+				"kind": "Pod",
+				"apiVersion": "v1",
+				"metadata": {"name": "malformed", "namespace": "default"},
+				"spec": {
+					"containers": [{"name": "hello", "image": "busybox"}],
+					"securityContext": {"sysctls": ` + tcase.sysctls + `}
+				}
+			}`)
+			payload, err := buildPodValidationRequest(object, &Settings{})
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			responsePayload, err := Validate(payload)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			if response.Accepted {
+				t.Fatalf("got unexpected approval")
+			}
+			if response.Code == nil || *response.Code != 400 {
+				t.Errorf("got code %v instead of 400", response.Code)
+			}
+			if *response.Message != tcase.error {
+				t.Errorf("got '%s' instead of '%s'", *response.Message, tcase.error)
+			}
+		})
+	}
+}
+
+func TestSpecLessObjects(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`{"forbiddenSysctls": ["*"]}`))
+	if err != nil {
+		t.Fatalf("unexpected error '%+v'", err)
+	}
+
+	for _, tcase := range []struct {
+		kind   string
+		object string
+	}{
+		{kind: "Pod", object: `{"metadata": {"name": "hello"}}`},
+		{kind: "Pod", object: `{"metadata": {"name": "hello"}, "spec": null}`},
+		{kind: "Deployment", object: `{"metadata": {"name": "hello"}}`},
+		{kind: "Deployment", object: `{"metadata": {"name": "hello"}, "spec": {"replicas": 1}}`},
+		{kind: "Deployment", object: `{"metadata": {"name": "hello"}, "spec": {"template": {"metadata": {}}}}`},
+		{kind: "ReplicaSet", object: `{"metadata": {"name": "hello"}, "spec": {}}`},
+		{kind: "StatefulSet", object: `{"metadata": {"name": "hello"}, "spec": {}}`},
+		{kind: "DaemonSet", object: `{"metadata": {"name": "hello"}, "spec": {}}`},
+		{kind: "ReplicationController", object: `{"metadata": {"name": "hello"}, "spec": {}}`},
+		{kind: "Job", object: `{"metadata": {"name": "hello"}, "spec": {"template": null}}`},
+		{kind: "CronJob", object: `{"metadata": {"name": "hello"}}`},
+		{kind: "CronJob", object: `{"metadata": {"name": "hello"}, "spec": {"jobTemplate": {}}}`},
+		{kind: "CronJob", object: `{"metadata": {"name": "hello"}, "spec": {"jobTemplate": {"spec": {"template": {}}}}}`},
+	} {
+		t.Run(tcase.kind+" "+tcase.object, func(t *testing.T) {
+			decision := Evaluate(&settings, kubewarden_protocol.KubernetesAdmissionRequest{
+				Kind:      kubewarden_protocol.GroupVersionKind{Kind: tcase.kind},
+				Namespace: "default",
+				Operation: "CREATE",
+				Object:    json.RawMessage(tcase.object),
+			})
+			if !decision.Accepted {
+				t.Errorf("got unexpected rejection: %s", decision.Message)
+			}
+		})
+	}
+}
+
+func TestMalformedWorkloadSysctls(t *testing.T) {
+	object := json.RawMessage(`{
+		"metadata": {"name": "cleanup"},
+		"spec": {"jobTemplate": {"spec": {"template": {"spec": {"securityContext": {"sysctls": [{"value": "1"}]}}}}}}
+	}`)
+
+	decision := Evaluate(&Settings{}, kubewarden_protocol.KubernetesAdmissionRequest{
+		Kind:      kubewarden_protocol.GroupVersionKind{Kind: "CronJob"},
+		Namespace: "default",
+		Operation: "CREATE",
+		Object:    object,
+	})
+	if decision.Accepted {
+		t.Fatalf("got unexpected approval")
+	}
+	expected := "spec.jobTemplate.spec.template.spec.securityContext.sysctls[0].name is required"
+	if decision.Message != expected || decision.Code != 400 {
+		t.Errorf("got '%s' with code %d instead of '%s' with code 400", decision.Message, decision.Code, expected)
+	}
+}
+
+func TestWorkloadTemplateMetadata(t *testing.T) {
+	now = func() time.Time {
+		return time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	}
+	defer func() { now = time.Now }()
+
+	const sysctls = `{"securityContext": {"sysctls": [{"name": "net.core.somaxconn", "value": "4096"}]}}`
+	const ingress = `{"labels": {"app.kubernetes.io/component": "ingress"}}`
+	const breakGlass = `{"annotations": {
+		"sysctl-psp.kubewarden.io/break-glass": "INC-1234",
+		"sysctl-psp.kubewarden.io/break-glass-expires": "2024-03-01T12:00:00Z"
+	}}`
+	const scoped = `{"allowedUnsafeSysctls": [{
+		"name": "net.core.somaxconn",
+		"selector": {"matchLabels": {"app.kubernetes.io/component": "ingress"}}
+	}]}`
+
+	for _, tcase := range []struct {
+		name     string
+		kind     string
+		object   string
+		settings string
+		message  string
+	}{
+		{
+			name:     "selector matching the template labels",
+			kind:     "Deployment",
+			object:   `{"metadata": {"name": "ingress"}, "spec": {"template": {"metadata": ` + ingress + `, "spec": ` + sysctls + `}}}`,
+			settings: scoped,
+		},
+		{
+			name:     "selector matching the workload labels only",
+			kind:     "Deployment",
+			object:   `{"metadata": ` + ingress + `, "spec": {"template": {"spec": ` + sysctls + `}}}`,
+			settings: scoped,
+			message:  "sysctl net.core.somaxconn is in the allowedUnsafeSysctls list, but not for this workload",
+		},
+		{
+			name:     "selector matching the CronJob template labels",
+			kind:     "CronJob",
+			object:   `{"spec": {"jobTemplate": {"spec": {"template": {"metadata": ` + ingress + `, "spec": ` + sysctls + `}}}}}`,
+			settings: scoped,
+		},
+		{
+			name:   "exception matching the template labels",
+			kind:   "StatefulSet",
+			object: `{"spec": {"template": {"metadata": ` + ingress + `, "spec": ` + sysctls + `}}}`,
+			settings: `{"exceptions": [{
+				"sysctl": "net.core.somaxconn",
+				"selector": {"matchLabels": {"app.kubernetes.io/component": "ingress"}},
+				"validUntil": "2024-03-01",
+				"reason": "migration of the legacy load balancer"
+			}]}`,
+		},
+		{
+			name:   "break-glass annotations on the template",
+			kind:   "Job",
+			object: `{"spec": {"template": {"metadata": ` + breakGlass + `, "spec": ` + sysctls + `}}}`,
+			settings: `{
+				"allowedUnsafeSysctls": ["net.core.somaxconn"],
+				"forbiddenSysctls": ["net.core.somaxconn"],
+				"breakGlass": {"enabled": true, "groups": ["sre"]}
+			}`,
+		},
+		{
+			name:   "break-glass annotations on the workload only",
+			kind:   "Job",
+			object: `{"metadata": ` + breakGlass + `, "spec": {"template": {"spec": ` + sysctls + `}}}`,
+			settings: `{
+				"allowedUnsafeSysctls": ["net.core.somaxconn"],
+				"forbiddenSysctls": ["net.core.somaxconn"],
+				"breakGlass": {"enabled": true, "groups": ["sre"]}
+			}`,
+			message: "sysctl net.core.somaxconn is on the forbidden list",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(tcase.settings))
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			decision := Evaluate(&settings, kubewarden_protocol.KubernetesAdmissionRequest{
+				Kind:      kubewarden_protocol.GroupVersionKind{Kind: tcase.kind},
+				Namespace: "default",
+				Operation: "CREATE",
+				UserInfo:  kubewarden_protocol.UserInfo{Username: "alice", Groups: []string{"sre"}},
+				Object:    json.RawMessage(tcase.object),
+			})
+			if decision.Accepted != (tcase.message == "") {
+				t.Fatalf("got accepted %v, message '%s'", decision.Accepted, decision.Message)
+			}
+			if decision.Message != tcase.message {
+				t.Errorf("got '%s' instead of '%s'", decision.Message, tcase.message)
+			}
+		})
+	}
+}
+
+// buildPodValidationRequest creates the payload for the invocation of the
+// `validate` function for a Pod CREATE request.
+func buildPodValidationRequest(object json.RawMessage, settings interface{}) ([]byte, error) {
+	settingsRaw, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(kubewarden_protocol.ValidationRequest{
+		Request: kubewarden_protocol.KubernetesAdmissionRequest{
+			Kind:      kubewarden_protocol.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Operation: "CREATE",
+			Object:    object,
+		},
+		Settings: settingsRaw,
+	})
+}
+
+func TestWarnEnforcementMode(t *testing.T) {
+	settings := Settings{
+		AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+		ForbiddenSysctls:     mapset.NewThreadUnsafeSet("net.*", "kernel.shm_rmid_forced"),
+		EnforcementMode:      EnforcementModeWarn,
+	}
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+		"../test_data/request-pod-safe-sysctls.json",
+		&settings)
+	if err != nil {
+		t.Fatalf("unexpected error '%+v'", err)
+	}
+
+	responsePayload, err := Validate(payload)
+	if err != nil {
+		t.Fatalf("unexpected error '%+v'", err)
+	}
+
+	var response validationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Fatalf("unexpected error '%+v'", err)
+	}
+
+	if !response.Accepted {
+		t.Errorf("got unexpected rejection")
+	}
+
+	expected := []string{
+		"sysctl kernel.shm_rmid_forced is on the forbidden list",
+		"sysctl net.ipv4.ip_local_port_range is on the forbidden list",
+		"sysctl net.ipv4.tcp_syncookies is on the forbidden list",
+		"sysctl net.ipv4.ping_group_range is on the forbidden list",
+	}
+	if len(response.Warnings) != len(expected) {
+		t.Fatalf("got warnings %v, wanted %v", response.Warnings, expected)
+	}
+	for i := range expected {
+		if response.Warnings[i] != expected[i] {
+			t.Errorf("got warning '%s', wanted '%s'", response.Warnings[i], expected[i])
+		}
+	}
+}
+
+func TestForbiddenSysctlActions(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		testData string
+		rule     ForbiddenSysctl
+		accepted bool
+		message  string
+		warnings []string
+	}{
+		{
+			name:     "deny with message",
+			testData: "../test_data/request-pod-safe-sysctls.json",
+			rule:     ForbiddenSysctl{Name: "kernel.shm_rmid_forced", Action: ActionDeny, Message: "ask the platform team"},
+			accepted: false,
+			message:  "sysctl kernel.shm_rmid_forced is on the forbidden list: ask the platform team",
+		},
+		{
+			name:     "warn",
+			testData: "../test_data/request-pod-safe-sysctls.json",
+			rule:     ForbiddenSysctl{Name: "net.ipv4.*", Action: ActionWarn, Message: "will be denied soon"},
+			accepted: true,
+			warnings: []string{
+				"sysctl net.ipv4.ip_local_port_range is on the forbidden list: will be denied soon",
+				"sysctl net.ipv4.tcp_syncookies is on the forbidden list: will be denied soon",
+				"sysctl net.ipv4.ping_group_range is on the forbidden list: will be denied soon",
+			},
+		},
+		{
+			name:     "log",
+			testData: "../test_data/request-pod-safe-sysctls.json",
+			rule:     ForbiddenSysctl{Name: "*", Action: ActionLog},
+			accepted: true,
+		},
+		{
+			name:     "warn does not allow unsafe sysctls",
+			testData: "../test_data/request-pod-somaxconn.json",
+			rule:     ForbiddenSysctl{Name: "net.*", Action: ActionWarn},
+			accepted: false,
+			message:  "sysctl net.core.somaxconn is not on safe list, nor is in the allowedUnsafeSysctls list",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			settings := Settings{
+				AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet[string](),
+				ForbiddenSysctls:     mapset.NewThreadUnsafeSet(tcase.rule.Name),
+				ForbiddenSysctlRules: map[string]ForbiddenSysctl{tcase.rule.Name: tcase.rule},
+			}
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				tcase.testData,
+				&settings)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			responsePayload, err := Validate(payload)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			var response validationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			if response.Accepted != tcase.accepted {
+				t.Fatalf("got accepted %v, wanted %v", response.Accepted, tcase.accepted)
+			}
+			if !tcase.accepted && *response.Message != tcase.message {
+				t.Errorf("got '%s' instead of '%s'", *response.Message, tcase.message)
+			}
+			if len(response.Warnings) != len(tcase.warnings) {
+				t.Fatalf("got warnings %v, wanted %v", response.Warnings, tcase.warnings)
+			}
+			for i := range tcase.warnings {
+				if response.Warnings[i] != tcase.warnings[i] {
+					t.Errorf("got warning '%s', wanted '%s'", response.Warnings[i], tcase.warnings[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDiscouragedSysctls(t *testing.T) {
+	settings := Settings{
+		AllowedUnsafeSysctls: mapset.NewThreadUnsafeSet("net.core.somaxconn"),
+		ForbiddenSysctls:     mapset.NewThreadUnsafeSet[string](),
+		DiscouragedSysctls: []DiscouragedSysctl{
+			{Name: "net.ipv4.tcp_tw_recycle", Reason: "removed from Linux 4.12"},
+			{Name: "net.core.*", Reason: "tune the node instead"},
+		},
+	}
+	payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+		"../test_data/request-pod-somaxconn.json",
+		&settings)
+	if err != nil {
+		t.Fatalf("unexpected error '%+v'", err)
+	}
+
+	responsePayload, err := Validate(payload)
+	if err != nil {
+		t.Fatalf("unexpected error '%+v'", err)
+	}
+
+	var response validationResponse
+	if err := json.Unmarshal(responsePayload, &response); err != nil {
+		t.Fatalf("unexpected error '%+v'", err)
+	}
+
+	if !response.Accepted {
+		t.Fatalf("got unexpected rejection")
+	}
+
+	expected := "sysctl net.core.somaxconn is discouraged: tune the node instead"
+	if len(response.Warnings) != 1 || response.Warnings[0] != expected {
+		t.Errorf("got warnings %v, wanted [%s]", response.Warnings, expected)
+	}
+}
+
+func TestScopedAllowedUnsafeSysctls(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		settings string
+		accepted bool
+		message  string
+	}{
+		{
+			name: "labels match the selector",
+			settings: `{"allowedUnsafeSysctls": [
+				{"name": "net.core.somaxconn", "selector": {"matchLabels": {"env": "test"}}}
+			]}`,
+			accepted: true,
+		},
+		{
+			name: "labels do not match the selector",
+			settings: `{"allowedUnsafeSysctls": [
+				{"name": "net.core.somaxconn", "selector": {"matchExpressions": [
+					{"key": "app.kubernetes.io/component", "operator": "In", "values": ["ingress"]}
+				]}}
+			]}`,
+			accepted: false,
+			message:  "sysctl net.core.somaxconn is in the allowedUnsafeSysctls list, but not for this workload",
+		},
+		{
+			name: "any of the rules can match",
+			settings: `{"allowedUnsafeSysctls": [
+				{"name": "net.core.somaxconn", "selector": {"matchLabels": {"env": "prod"}}},
+				{"name": "net.core.somaxconn", "selector": {"matchLabels": {"env": "test"}}}
+			]}`,
+			accepted: true,
+		},
+		{
+			name: "all the images match",
+			settings: `{"allowedUnsafeSysctls": [
+				{"name": "net.core.somaxconn", "images": ["docker.io/library/busybox"]}
+			]}`,
+			accepted: true,
+		},
+		{
+			name: "images do not match",
+			settings: `{"allowedUnsafeSysctls": [
+				{"name": "net.core.somaxconn", "images": ["registry.example.com/lb/*"], "imagesMatch": "any"}
+			]}`,
+			accepted: false,
+			message:  "sysctl net.core.somaxconn is in the allowedUnsafeSysctls list, but not for this workload",
+		},
+		{
+			name: "images and labels must both match",
+			settings: `{"allowedUnsafeSysctls": [
+				{"name": "net.core.somaxconn", "images": ["busybox"], "selector": {"matchLabels": {"env": "prod"}}}
+			]}`,
+			accepted: false,
+			message:  "sysctl net.core.somaxconn is in the allowedUnsafeSysctls list, but not for this workload",
+		},
+		{
+			name: "service account matches",
+			settings: `{"allowedUnsafeSysctls": [
+				{"name": "net.core.somaxconn", "serviceAccounts": ["monitoring/node-tuner", "default/default"]}
+			]}`,
+			accepted: true,
+		},
+		{
+			name: "service account in another namespace",
+			settings: `{"allowedUnsafeSysctls": [
+				{"name": "net.core.somaxconn", "serviceAccounts": ["monitoring/default"]}
+			]}`,
+			accepted: false,
+			message:  "sysctl net.core.somaxconn is in the allowedUnsafeSysctls list, but not for this workload",
+		},
+		{
+			name: "forbidden patterns apply outside of the scope",
+			settings: `{
+				"allowedUnsafeSysctls": [{"name": "net.core.somaxconn", "selector": {"matchLabels": {"env": "prod"}}}],
+				"forbiddenSysctls": ["net.*"]
+			}`,
+			accepted: false,
+			message:  "sysctl net.core.somaxconn is on the forbidden list",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+				"../test_data/request-pod-somaxconn.json",
+				json.RawMessage(tcase.settings))
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			responsePayload, err := Validate(payload)
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			var response kubewarden_protocol.ValidationResponse
+			if err := json.Unmarshal(responsePayload, &response); err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+
+			if response.Accepted != tcase.accepted {
+				t.Fatalf("got accepted %v, wanted %v", response.Accepted, tcase.accepted)
+			}
+			if !tcase.accepted && *response.Message != tcase.message {
+				t.Errorf("got '%s' instead of '%s'", *response.Message, tcase.message)
+			}
+		})
+	}
+}
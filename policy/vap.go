@@ -0,0 +1,478 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidatingAdmissionPolicy is the subset of the
+// admissionregistration.k8s.io/v1 ValidatingAdmissionPolicy used to export
+// the settings as in-tree CEL admission.
+type ValidatingAdmissionPolicy struct {
+	APIVersion string                        `json:"apiVersion"`
+	Kind       string                        `json:"kind"`
+	Metadata   ValidatingAdmissionMetadata   `json:"metadata"`
+	Spec       ValidatingAdmissionPolicySpec `json:"spec"`
+}
+
+// ValidatingAdmissionMetadata is the metadata of the exported objects.
+type ValidatingAdmissionMetadata struct {
+	Name string `json:"name"`
+}
+
+// ValidatingAdmissionPolicySpec is the spec of a ValidatingAdmissionPolicy.
+type ValidatingAdmissionPolicySpec struct {
+	FailurePolicy    string                      `json:"failurePolicy"`
+	MatchConstraints ValidatingAdmissionMatch    `json:"matchConstraints"`
+	MatchConditions  []ValidatingAdmissionNamed  `json:"matchConditions,omitempty"`
+	Variables        []ValidatingAdmissionNamed  `json:"variables"`
+	Validations      []ValidatingAdmissionVerify `json:"validations"`
+}
+
+// ValidatingAdmissionMatch selects the requests evaluated by the policy.
+type ValidatingAdmissionMatch struct {
+	ResourceRules []ValidatingAdmissionResourceRule `json:"resourceRules"`
+}
+
+// ValidatingAdmissionResourceRule is a rule of the matchConstraints.
+type ValidatingAdmissionResourceRule struct {
+	APIGroups   []string `json:"apiGroups"`
+	APIVersions []string `json:"apiVersions"`
+	Operations  []string `json:"operations"`
+	Resources   []string `json:"resources"`
+}
+
+// ValidatingAdmissionNamed is a named CEL expression, either a match
+// condition or a variable.
+type ValidatingAdmissionNamed struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+}
+
+// ValidatingAdmissionVerify is a validation of the policy, the request is
+// rejected when the expression evaluates to false.
+type ValidatingAdmissionVerify struct {
+	Expression        string `json:"expression"`
+	MessageExpression string `json:"messageExpression"`
+	Reason            string `json:"reason"`
+}
+
+// ValidatingAdmissionPolicyBinding is the subset of the
+// admissionregistration.k8s.io/v1 ValidatingAdmissionPolicyBinding binding
+// the exported policy to the whole cluster.
+type ValidatingAdmissionPolicyBinding struct {
+	APIVersion string                               `json:"apiVersion"`
+	Kind       string                               `json:"kind"`
+	Metadata   ValidatingAdmissionMetadata          `json:"metadata"`
+	Spec       ValidatingAdmissionPolicyBindingSpec `json:"spec"`
+}
+
+// ValidatingAdmissionPolicyBindingSpec is the spec of a
+// ValidatingAdmissionPolicyBinding.
+type ValidatingAdmissionPolicyBindingSpec struct {
+	PolicyName        string   `json:"policyName"`
+	ValidationActions []string `json:"validationActions"`
+}
+
+const validatingAdmissionAPIVersion = "admissionregistration.k8s.io/v1"
+
+// podSpecExpression returns the Pod spec of the objects supported by
+// the policy, the Pods and the workloads with a Pod template.
+const podSpecExpression = `object.kind == "Pod" ? object.spec : ` +
+	`(object.kind == "CronJob" ? object.spec.jobTemplate.spec.template.spec : object.spec.template.spec)`
+
+// podLabelsExpression returns the labels of the Pods, or of the Pod template
+// of the workloads, the ones the policy scopes the rules to.
+const podLabelsExpression = `object.kind == "Pod" ? ` +
+	`(has(object.metadata.labels) ? object.metadata.labels : {}) : ` +
+	`(object.kind == "CronJob" ? ` +
+	`(has(object.spec.jobTemplate.spec.template.metadata) && ` +
+	`has(object.spec.jobTemplate.spec.template.metadata.labels) ? ` +
+	`object.spec.jobTemplate.spec.template.metadata.labels : {}) : ` +
+	`(has(object.spec.template.metadata) && has(object.spec.template.metadata.labels) ? ` +
+	`object.spec.template.metadata.labels : {}))`
+
+// ExportValidatingAdmissionPolicy returns a ValidatingAdmissionPolicy, and
+// its binding, taking the same decisions of the policy configured with the
+// settings. The features that cannot be expressed in CEL are returned too,
+// the exported policy rejects the requests they would have accepted.
+//
+// The decisions only are the same, the rejection messages are different.
+func (s *Settings) ExportValidatingAdmissionPolicy(
+	name string,
+) (ValidatingAdmissionPolicy, ValidatingAdmissionPolicyBinding, []string, error) {
+	if len(s.Rules) != 0 {
+		return ValidatingAdmissionPolicy{}, ValidatingAdmissionPolicyBinding{}, nil,
+			fmt.Errorf("rules cannot be exported, use allowedUnsafeSysctls and forbiddenSysctls instead")
+	}
+
+	unexported := s.unexportedFeatures()
+	variables := []ValidatingAdmissionNamed{
+		{Name: "podSpec", Expression: podSpecExpression},
+		{
+			Name: "sysctls",
+			Expression: "has(variables.podSpec.securityContext) && has(variables.podSpec.securityContext.sysctls) ? " +
+				"variables.podSpec.securityContext.sysctls.map(s, s.name) : []",
+		},
+	}
+
+	allowed, scopes, notExpressed := s.allowedExpression()
+	unexported = append(unexported, notExpressed...)
+	if scopes.serviceAccounts {
+		variables = append(variables,
+			ValidatingAdmissionNamed{
+				Name: "namespace",
+				Expression: `has(object.metadata.namespace) && object.metadata.namespace != "" ? ` +
+					`object.metadata.namespace : request.namespace`,
+			},
+			ValidatingAdmissionNamed{
+				Name: "serviceAccount",
+				Expression: `variables.namespace + "/" + (` +
+					`has(variables.podSpec.serviceAccountName) && variables.podSpec.serviceAccountName != "" ? ` +
+					`variables.podSpec.serviceAccountName : (` +
+					`has(variables.podSpec.serviceAccount) && variables.podSpec.serviceAccount != "" ? ` +
+					`variables.podSpec.serviceAccount : "default"))`,
+			})
+	}
+	if scopes.labels {
+		variables = append(variables, ValidatingAdmissionNamed{
+			Name:       "labels",
+			Expression: podLabelsExpression,
+		})
+	}
+	variables = append(variables,
+		ValidatingAdmissionNamed{
+			Name:       "allowed",
+			Expression: fmt.Sprintf("variables.sysctls.filter(s, %s)", allowed),
+		},
+		ValidatingAdmissionNamed{
+			Name:       "forbidden",
+			Expression: fmt.Sprintf("variables.sysctls.filter(s, %s)", s.forbiddenExpression()),
+		},
+		ValidatingAdmissionNamed{
+			Name: "notAllowed",
+			Expression: fmt.Sprintf("variables.sysctls.filter(s, !(s in %s) && !(s in variables.allowed))",
+				celList(CreateSafeSysctlsSet().ToSlice())),
+		})
+
+	policy := ValidatingAdmissionPolicy{
+		APIVersion: validatingAdmissionAPIVersion,
+		Kind:       "ValidatingAdmissionPolicy",
+		Metadata:   ValidatingAdmissionMetadata{Name: name},
+		Spec: ValidatingAdmissionPolicySpec{
+			FailurePolicy: "Fail",
+			MatchConstraints: ValidatingAdmissionMatch{
+				ResourceRules: []ValidatingAdmissionResourceRule{
+					{
+						APIGroups:   []string{""},
+						APIVersions: []string{"v1"},
+						Operations:  []string{"CREATE", "UPDATE"},
+						Resources:   []string{"pods", "replicationcontrollers"},
+					},
+					{
+						APIGroups:   []string{"apps"},
+						APIVersions: []string{"v1"},
+						Operations:  []string{"CREATE", "UPDATE"},
+						Resources:   []string{"daemonsets", "deployments", "replicasets", "statefulsets"},
+					},
+					{
+						APIGroups:   []string{"batch"},
+						APIVersions: []string{"v1"},
+						Operations:  []string{"CREATE", "UPDATE"},
+						Resources:   []string{"cronjobs", "jobs"},
+					},
+				},
+			},
+			MatchConditions: s.exemptNamespacesConditions(),
+			Variables:       variables,
+			Validations: []ValidatingAdmissionVerify{
+				{
+					Expression:        "size(variables.forbidden) == 0",
+					MessageExpression: `"sysctls on the forbidden list: " + variables.forbidden.join(", ")`,
+					Reason:            "Forbidden",
+				},
+				{
+					Expression: "size(variables.notAllowed) == 0",
+					MessageExpression: `"sysctls not on safe list, nor in the allowedUnsafeSysctls list: " + ` +
+						`variables.notAllowed.join(", ")`,
+					Reason: "Forbidden",
+				},
+			},
+		},
+	}
+
+	action := "Deny"
+	if s.EnforcementMode == EnforcementModeWarn {
+		action = "Warn"
+	}
+	binding := ValidatingAdmissionPolicyBinding{
+		APIVersion: validatingAdmissionAPIVersion,
+		Kind:       "ValidatingAdmissionPolicyBinding",
+		Metadata:   ValidatingAdmissionMetadata{Name: name},
+		Spec: ValidatingAdmissionPolicyBindingSpec{
+			PolicyName:        name,
+			ValidationActions: []string{action},
+		},
+	}
+
+	return policy, binding, unexported, nil
+}
+
+// unexportedFeatures returns the settings that have no CEL equivalent.
+func (s *Settings) unexportedFeatures() []string {
+	unexported := []string{}
+	if s.GrandfatherExisting {
+		unexported = append(unexported,
+			"grandfatherExisting: not exported, all the sysctls of UPDATE requests are evaluated")
+	}
+	if len(s.DiscouragedSysctls) != 0 {
+		unexported = append(unexported,
+			"discouragedSysctls: not exported, the discouraged sysctls are accepted without warnings")
+	}
+	if s.BreakGlass.Enabled {
+		unexported = append(unexported,
+			"breakGlass: not exported, the forbidden sysctls cannot be bypassed")
+	}
+	if len(s.Exceptions) != 0 {
+		unexported = append(unexported,
+			"exceptions: not exported, the sysctls they allow are rejected")
+	}
+	if s.RejectionMessageTemplate != "" || s.RejectionCode != 0 || s.DocsURL != "" ||
+		s.StructuredRejection || s.Explain {
+		unexported = append(unexported,
+			"rejectionMessageTemplate, rejectionCode, docsUrl, structuredRejection and explain: "+
+				"not exported, the rejection messages are built by the validations")
+	}
+	for _, rule := range s.forbiddenSysctlsList() {
+		switch rule.action() {
+		case ActionWarn:
+			unexported = append(unexported,
+				fmt.Sprintf("forbiddenSysctls entry %s: action warn not exported, the sysctl is accepted without warnings",
+					rule.Name))
+		case ActionLog:
+			unexported = append(unexported,
+				fmt.Sprintf("forbiddenSysctls entry %s: action log not exported, the sysctl is accepted without logging",
+					rule.Name))
+		}
+	}
+	return unexported
+}
+
+// exportedScopes tells which variables are needed by the scoped
+// allowedUnsafeSysctls entries.
+type exportedScopes struct {
+	serviceAccounts bool
+	labels          bool
+}
+
+// allowedExpression returns the CEL expression matching the sysctls `s`
+// allowed by allowedUnsafeSysctls. The entries scoped to images cannot be
+// expressed, they are returned as not exported.
+func (s *Settings) allowedExpression() (string, exportedScopes, []string) {
+	scopes := exportedScopes{}
+	unexported := []string{}
+
+	unscoped := []string{}
+	conditions := []string{}
+	for _, rule := range s.allowedUnsafeSysctlsList() {
+		if !s.allowedUnsafeSysctlScoped(rule.Name) {
+			if len(unscoped) == 0 || unscoped[len(unscoped)-1] != rule.Name {
+				unscoped = append(unscoped, rule.Name)
+			}
+			continue
+		}
+		if !rule.scoped() {
+			continue
+		}
+		if len(rule.Images) != 0 {
+			unexported = append(unexported,
+				fmt.Sprintf("allowedUnsafeSysctls entry %s: images scope not exported, the sysctl is not allowed by it",
+					rule.Name))
+			continue
+		}
+
+		condition := []string{"s == " + celString(rule.Name)}
+		if rule.Selector != nil {
+			scopes.labels = true
+			condition = append(condition, selectorExpression(rule.Selector)...)
+		}
+		if len(rule.ServiceAccounts) != 0 {
+			scopes.serviceAccounts = true
+			condition = append(condition, "variables.serviceAccount in "+celList(rule.ServiceAccounts))
+		}
+		conditions = append(conditions, "("+strings.Join(condition, " && ")+")")
+	}
+
+	if len(unscoped) != 0 || len(conditions) == 0 {
+		conditions = append([]string{"s in " + celList(unscoped)}, conditions...)
+	}
+	return strings.Join(conditions, " || "), scopes, unexported
+}
+
+// selectorExpression returns the CEL expressions matching the labels of the
+// object against the selector, all of them must be true.
+func selectorExpression(selector *LabelSelector) []string {
+	expressions := []string{}
+
+	keys := []string{}
+	for key := range selector.MatchLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		expressions = append(expressions, fmt.Sprintf("%s in variables.labels && variables.labels[%s] == %s",
+			celString(key), celString(key), celString(selector.MatchLabels[key])))
+	}
+
+	for _, requirement := range selector.MatchExpressions {
+		key := celString(requirement.Key)
+		switch requirement.Operator {
+		case LabelSelectorOpIn:
+			expressions = append(expressions, fmt.Sprintf("%s in variables.labels && variables.labels[%s] in %s",
+				key, key, celList(requirement.Values)))
+		case LabelSelectorOpNotIn:
+			expressions = append(expressions, fmt.Sprintf("(!(%s in variables.labels) || !(variables.labels[%s] in %s))",
+				key, key, celList(requirement.Values)))
+		case LabelSelectorOpExists:
+			expressions = append(expressions, fmt.Sprintf("%s in variables.labels", key))
+		case LabelSelectorOpDoesNotExist:
+			expressions = append(expressions, fmt.Sprintf("!(%s in variables.labels)", key))
+		}
+	}
+	return expressions
+}
+
+// forbiddenExpression returns the CEL expression matching the sysctls `s`
+// denied by forbiddenSysctls. Like validateSysctl, an exact entry takes
+// precedence over the patterns, and the longest matching pattern decides,
+// unless the sysctl is allowed.
+func (s *Settings) forbiddenExpression() string {
+	exact := []string{}
+	exactDenied := []string{}
+	patterns := []ForbiddenSysctl{}
+	for _, rule := range s.forbiddenSysctlsList() {
+		if !isSysctlPattern(rule.Name) {
+			exact = append(exact, rule.Name)
+			if rule.action() == ActionDeny {
+				exactDenied = append(exactDenied, rule.Name)
+			}
+			continue
+		}
+		patterns = append(patterns, rule)
+	}
+
+	denied := []string{}
+	for _, pattern := range patterns {
+		if pattern.action() != ActionDeny {
+			continue
+		}
+		condition := []string{patternExpression(pattern.Name)}
+		// a longer pattern, with another action, takes precedence
+		for _, other := range patterns {
+			if other.action() != ActionDeny && len(other.Name) > len(pattern.Name) &&
+				matchesSysctlPattern(pattern.Name, strings.TrimSuffix(other.Name, "*")) {
+				condition = append(condition, "!"+patternExpression(other.Name))
+			}
+		}
+		denied = append(denied, strings.Join(condition, " && "))
+	}
+
+	expression := "s in " + celList(exactDenied)
+	if len(denied) != 0 {
+		expression = fmt.Sprintf("%s || (!(s in %s) && !(s in variables.allowed) && (%s))",
+			expression, celList(exact), strings.Join(denied, " || "))
+	}
+	return expression
+}
+
+// patternExpression returns the CEL expression matching the sysctls `s`
+// matched by a forbiddenSysctls pattern.
+func patternExpression(pattern string) string {
+	if pattern == "*" {
+		return "true"
+	}
+	return fmt.Sprintf("s.startsWith(%s)", celString(strings.TrimSuffix(pattern, "*")))
+}
+
+// exemptNamespacesConditions returns the match conditions skipping the
+// requests of the exempted namespaces.
+func (s *Settings) exemptNamespacesConditions() []ValidatingAdmissionNamed {
+	names := []string{}
+	conditions := []ValidatingAdmissionNamed{}
+	for _, namespace := range s.ExemptNamespaces {
+		if !strings.ContainsAny(namespace, `*?[\`) {
+			names = append(names, namespace)
+			continue
+		}
+		conditions = append(conditions, ValidatingAdmissionNamed{
+			Name:       fmt.Sprintf("exempt-namespaces-glob-%d", len(conditions)+1),
+			Expression: fmt.Sprintf("!request.namespace.matches(%s)", celString(globRegexp(namespace))),
+		})
+	}
+	if len(names) != 0 {
+		conditions = append([]ValidatingAdmissionNamed{{
+			Name:       "exempt-namespaces",
+			Expression: fmt.Sprintf("!(request.namespace in %s)", celList(names)),
+		}}, conditions...)
+	}
+	return conditions
+}
+
+// globRegexp translates a path.Match glob into a regular expression matching
+// the same strings. Namespaces cannot contain `/`.
+func globRegexp(glob string) string {
+	var expression strings.Builder
+	expression.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			expression.WriteString(".*")
+		case '?':
+			expression.WriteString(".")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				expression.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				expression.WriteString(regexp.QuoteMeta(glob[i:]))
+				i = len(glob)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			expression.WriteString("[")
+			if strings.HasPrefix(class, "^") {
+				expression.WriteString("^")
+				class = class[1:]
+			}
+			expression.WriteString(class)
+			expression.WriteString("]")
+			i += end + 1
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expression.WriteString("$")
+	return expression.String()
+}
+
+// celString returns the CEL string literal of the given value.
+func celString(value string) string {
+	return strconv.Quote(value)
+}
+
+// celList returns the CEL list literal of the given values, sorted.
+func celList(values []string) string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	literals := []string{}
+	for _, value := range sorted {
+		literals = append(literals, celString(value))
+	}
+	return "[" + strings.Join(literals, ", ") + "]"
+}
@@ -0,0 +1,129 @@
+package policy
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestExportValidatingAdmissionPolicyUnexported(t *testing.T) {
+	for _, tcase := range []struct {
+		name       string
+		settings   string
+		unexported []string
+	}{
+		{
+			name: "images scope",
+			settings: `{"allowedUnsafeSysctls": [
+				{"name": "net.core.somaxconn", "images": ["registry.example.com/lb/*"]}
+			]}`,
+			unexported: []string{
+				"allowedUnsafeSysctls entry net.core.somaxconn: images scope not exported, the sysctl is not allowed by it",
+			},
+		},
+		{
+			name:     "warn action",
+			settings: `{"forbiddenSysctls": [{"name": "net.*", "action": "warn"}]}`,
+			unexported: []string{
+				"forbiddenSysctls entry net.*: action warn not exported, the sysctl is accepted without warnings",
+			},
+		},
+		{
+			name: "runtime features",
+			settings: `{
+				"grandfatherExisting": true,
+				"discouragedSysctls": [{"name": "kernel.shm_rmid_forced"}],
+				"breakGlass": {"enabled": true, "groups": ["sre"]},
+				"exceptions": [{
+					"sysctl": "net.core.somaxconn",
+					"namespaces": ["default"],
+					"validUntil": "2030-01-01",
+					"reason": "migration"
+				}],
+				"explain": true
+			}`,
+			unexported: []string{
+				"grandfatherExisting: not exported, all the sysctls of UPDATE requests are evaluated",
+				"discouragedSysctls: not exported, the discouraged sysctls are accepted without warnings",
+				"breakGlass: not exported, the forbidden sysctls cannot be bypassed",
+				"exceptions: not exported, the sysctls they allow are rejected",
+				"rejectionMessageTemplate, rejectionCode, docsUrl, structuredRejection and explain: " +
+					"not exported, the rejection messages are built by the validations",
+			},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			settings, err := NewSettingsFromValidateSettingsPayload([]byte(tcase.settings))
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+			_, _, unexported, err := settings.ExportValidatingAdmissionPolicy("sysctl-psp")
+			if err != nil {
+				t.Fatalf("unexpected error '%+v'", err)
+			}
+			if !reflect.DeepEqual(unexported, tcase.unexported) {
+				t.Errorf("got %q, wanted %q", unexported, tcase.unexported)
+			}
+		})
+	}
+}
+
+func TestExportValidatingAdmissionPolicyRules(t *testing.T) {
+	settings, err := NewSettingsFromValidateSettingsPayload([]byte(`{"rules": [{"match": "*", "action": "deny"}]}`))
+	if err != nil {
+		t.Fatalf("unexpected error '%+v'", err)
+	}
+
+	_, _, _, err = settings.ExportValidatingAdmissionPolicy("sysctl-psp")
+	expected := "rules cannot be exported, use allowedUnsafeSysctls and forbiddenSysctls instead"
+	if err == nil || err.Error() != expected {
+		t.Errorf("got error '%v', wanted '%s'", err, expected)
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	for glob, expected := range map[string]string{
+		"kube-system": `^kube-system$`,
+		"cilium-*":    `^cilium-.*$`,
+		"team-?":      `^team-.$`,
+		"ns-[a-c]":    `^ns-[a-c]$`,
+		"ns-[^a-c]":   `^ns-[^a-c]$`,
+		`ns-\*`:       `^ns-\*$`,
+	} {
+		if actual := globRegexp(glob); actual != expected {
+			t.Errorf("got %s for %s, wanted %s", actual, glob, expected)
+		}
+	}
+}
+
+// the exported objects are written in YAML by the users, make sure they
+// don't depend on the order of the sets
+func TestExportValidatingAdmissionPolicyIsStable(t *testing.T) {
+	raw, err := os.ReadFile("../settings.sample.json")
+	if err != nil {
+		t.Fatalf("unexpected error '%+v'", err)
+	}
+	export := func() []byte {
+		settings, err := NewSettingsFromValidateSettingsPayload(raw)
+		if err != nil {
+			t.Fatalf("unexpected error '%+v'", err)
+		}
+		policy, _, _, err := settings.ExportValidatingAdmissionPolicy("sysctl-psp")
+		if err != nil {
+			t.Fatalf("unexpected error '%+v'", err)
+		}
+		exported, err := json.Marshal(policy)
+		if err != nil {
+			t.Fatalf("unexpected error '%+v'", err)
+		}
+		return exported
+	}
+
+	first := export()
+	for i := 0; i < 10; i++ {
+		if exported := export(); string(exported) != string(first) {
+			t.Fatalf("got %s, then %s", first, exported)
+		}
+	}
+}
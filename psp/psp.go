@@ -0,0 +1,148 @@
+// Package psp converts PodSecurityPolicy objects into settings of the
+// sysctl-psp policy.
+//
+// The sysctl-psp policy replaces the sysctls part of the PodSecurityPolicy,
+// its settings have the same meaning of the `allowedUnsafeSysctls` and
+// `forbiddenSysctls` fields of the PodSecurityPolicy spec. All the other PSP
+// features are reported as not translated.
+package psp
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kubewarden/go-policy-template/internal/convert"
+	"github.com/kubewarden/go-policy-template/manifest"
+)
+
+// Kind is the kind of the PodSecurityPolicy objects
+const Kind = "PodSecurityPolicy"
+
+// PodSecurityPolicy is the subset of the PodSecurityPolicy object read by
+// the converter. The spec fields that are not about sysctls are kept raw, to
+// be reported.
+type PodSecurityPolicy struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name        string            `json:"name"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	} `json:"metadata"`
+	Spec map[string]json.RawMessage `json:"spec"`
+}
+
+// Result is the conversion of a PodSecurityPolicy.
+type Result struct {
+	// The name of the PodSecurityPolicy
+	Name     string
+	Settings convert.Settings
+	// The features of the PodSecurityPolicy that could not be translated
+	Untranslated []string
+}
+
+// Convert reads the PodSecurityPolicy objects, either YAML or JSON, found
+// in data and converts them. data can hold multiple YAML documents and
+// lists. The objects of other kinds are ignored.
+func Convert(data []byte) ([]Result, error) {
+	policies, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, fmt.Errorf("no %s found", Kind)
+	}
+
+	results := []Result{}
+	for i := range policies {
+		result, err := ConvertPolicy(&policies[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", Kind, policies[i].Metadata.Name, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// ConvertPolicy converts a single PodSecurityPolicy. An error is returned
+// when the sysctls of the PodSecurityPolicy are not well formed.
+func ConvertPolicy(policy *PodSecurityPolicy) (Result, error) {
+	allowed := []string{}
+	if err := unmarshalSpecField(policy, "allowedUnsafeSysctls", &allowed); err != nil {
+		return Result{}, err
+	}
+	forbidden := []string{}
+	if err := unmarshalSpecField(policy, "forbiddenSysctls", &forbidden); err != nil {
+		return Result{}, err
+	}
+
+	settings, untranslated, err := convert.Sysctls(allowed, forbidden)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{
+		Name:         policy.Metadata.Name,
+		Settings:     settings,
+		Untranslated: []string{},
+	}
+	for _, feature := range untranslated {
+		result.Untranslated = append(result.Untranslated, "spec."+feature)
+	}
+
+	fields := []string{}
+	for field := range policy.Spec {
+		if field != "allowedUnsafeSysctls" && field != "forbiddenSysctls" {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		result.Untranslated = append(result.Untranslated,
+			fmt.Sprintf("spec.%s: not about sysctls", field))
+	}
+
+	annotations := []string{}
+	for annotation := range policy.Metadata.Annotations {
+		if strings.Contains(annotation, "seccomp") || strings.Contains(annotation, "apparmor") {
+			annotations = append(annotations, annotation)
+		}
+	}
+	sort.Strings(annotations)
+	for _, annotation := range annotations {
+		result.Untranslated = append(result.Untranslated,
+			fmt.Sprintf("metadata.annotations[%s]: not about sysctls", annotation))
+	}
+
+	return result, nil
+}
+
+// unmarshalSpecField decodes the given field of the spec, when it's set.
+func unmarshalSpecField(policy *PodSecurityPolicy, field string, value interface{}) error {
+	raw, found := policy.Spec[field]
+	if !found {
+		return nil
+	}
+	if err := json.Unmarshal(raw, value); err != nil {
+		return fmt.Errorf("spec.%s must be a list of strings: %w", field, err)
+	}
+	return nil
+}
+
+// decode returns the PodSecurityPolicy objects found in data.
+func decode(data []byte) ([]PodSecurityPolicy, error) {
+	objects, err := manifest.DecodeKind(data, Kind)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := []PodSecurityPolicy{}
+	for _, object := range objects {
+		policy := PodSecurityPolicy{}
+		if err := json.Unmarshal(object, &policy); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
@@ -0,0 +1,150 @@
+package psp
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/kubewarden/go-policy-template/internal/convert"
+)
+
+func TestConvert(t *testing.T) {
+	for _, tcase := range []struct {
+		fixture string
+		results []Result
+	}{
+		{
+			fixture: "../test_data/psp/restricted.yaml",
+			results: []Result{
+				{
+					Name: "restricted",
+					Settings: convert.Settings{
+						AllowedUnsafeSysctls: []string{},
+						ForbiddenSysctls:     []string{"*"},
+					},
+					Untranslated: []string{
+						"spec.allowPrivilegeEscalation: not about sysctls",
+						"spec.fsGroup: not about sysctls",
+						"spec.hostIPC: not about sysctls",
+						"spec.hostNetwork: not about sysctls",
+						"spec.hostPID: not about sysctls",
+						"spec.privileged: not about sysctls",
+						"spec.requiredDropCapabilities: not about sysctls",
+						"spec.runAsUser: not about sysctls",
+						"spec.seLinux: not about sysctls",
+						"spec.supplementalGroups: not about sysctls",
+						"spec.volumes: not about sysctls",
+						"metadata.annotations[seccomp.security.alpha.kubernetes.io/allowedProfileNames]: not about sysctls",
+						"metadata.annotations[seccomp.security.alpha.kubernetes.io/defaultProfileName]: not about sysctls",
+					},
+				},
+			},
+		},
+		{
+			fixture: "../test_data/psp/sysctls.yaml",
+			results: []Result{
+				{
+					Name: "network-tuning",
+					Settings: convert.Settings{
+						AllowedUnsafeSysctls: []string{"net.core.somaxconn"},
+						ForbiddenSysctls:     []string{"kernel.shm_rmid_forced", "net.ipv4.ip_*"},
+					},
+					Untranslated: []string{
+						"spec.allowedUnsafeSysctls entry net.ipv4.tcp_*: patterns are not supported, list the sysctls explicitly",
+						"spec.fsGroup: not about sysctls",
+						"spec.runAsUser: not about sysctls",
+						"spec.seLinux: not about sysctls",
+						"spec.supplementalGroups: not about sysctls",
+					},
+				},
+				{
+					Name: "ipc",
+					Settings: convert.Settings{
+						AllowedUnsafeSysctls: []string{"kernel.msgmax", "kernel.sem"},
+						ForbiddenSysctls:     []string{},
+					},
+					Untranslated: []string{},
+				},
+			},
+		},
+		{
+			fixture: "../test_data/psp/list.json",
+			results: []Result{
+				{
+					Name: "databases",
+					Settings: convert.Settings{
+						AllowedUnsafeSysctls: []string{"kernel.shmmax", "kernel.shmall"},
+						ForbiddenSysctls:     []string{"net.*"},
+					},
+					Untranslated: []string{},
+				},
+			},
+		},
+	} {
+		t.Run(tcase.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(tcase.fixture)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			results, err := Convert(data)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if !reflect.DeepEqual(results, tcase.results) {
+				t.Errorf("got %+v instead of %+v", results, tcase.results)
+			}
+		})
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	for _, tcase := range []struct {
+		name  string
+		data  string
+		error string
+	}{
+		{
+			name: "no PodSecurityPolicy",
+			data: `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: default
+`,
+			error: "no PodSecurityPolicy found",
+		},
+		{
+			name: "pattern with * not as suffix",
+			data: `
+apiVersion: policy/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: broken
+spec:
+  forbiddenSysctls: ["net.*.somaxconn"]
+`,
+			error: "PodSecurityPolicy broken: forbiddenSysctls entry net.*.somaxconn: `*` can only be used as suffix",
+		},
+		{
+			name: "not a list",
+			data: `
+apiVersion: policy/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: broken
+spec:
+  forbiddenSysctls: net.*
+`,
+			error: "PodSecurityPolicy broken: spec.forbiddenSysctls must be a list of strings: " +
+				"json: cannot unmarshal string into Go value of type []string",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			_, err := Convert([]byte(tcase.data))
+			if err == nil || err.Error() != tcase.error {
+				t.Errorf("got error '%v', wanted '%s'", err, tcase.error)
+			}
+		})
+	}
+}
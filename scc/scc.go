@@ -0,0 +1,361 @@
+// Package scc converts OpenShift SecurityContextConstraints objects into
+// settings of the sysctl-psp policy.
+//
+// The `allowedUnsafeSysctls` and `forbiddenSysctls` fields of the
+// SecurityContextConstraints have the same meaning of the PodSecurityPolicy
+// ones. Each SecurityContextConstraints can be converted into its own
+// settings, or all of them can be merged into a single settings instance
+// whose allowedUnsafeSysctls are scoped to the service accounts the
+// SecurityContextConstraints are granted to.
+package scc
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kubewarden/go-policy-template/internal/convert"
+	"github.com/kubewarden/go-policy-template/manifest"
+)
+
+const (
+	// Kind is the kind of the SecurityContextConstraints objects
+	Kind = "SecurityContextConstraints"
+
+	serviceAccountUserPrefix = "system:serviceaccount:"
+	serviceAccountsGroup     = "system:serviceaccounts"
+	authenticatedGroup       = "system:authenticated"
+)
+
+// SecurityContextConstraints is the subset of the SecurityContextConstraints
+// object read by the converter. Unlike the PodSecurityPolicy, the fields are
+// at the top level of the object, all of them are kept raw to be reported.
+type SecurityContextConstraints struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Users  []string                   `json:"users"`
+	Groups []string                   `json:"groups"`
+	Fields map[string]json.RawMessage `json:"-"`
+}
+
+// Result is the conversion of a SecurityContextConstraints.
+type Result struct {
+	// The name of the SecurityContextConstraints
+	Name     string
+	Settings convert.Settings
+	// The features of the SecurityContextConstraints that could not be
+	// translated
+	Untranslated []string
+}
+
+// AllowedUnsafeSysctl is an allowedUnsafeSysctls entry of the scoped
+// settings. It's written as a plain string when it applies to all the Pods.
+type AllowedUnsafeSysctl struct {
+	Name            string   `json:"name"`
+	Description     string   `json:"description,omitempty"`
+	ServiceAccounts []string `json:"serviceAccounts,omitempty"`
+}
+
+func (a AllowedUnsafeSysctl) MarshalJSON() ([]byte, error) {
+	if a.Description == "" && len(a.ServiceAccounts) == 0 {
+		return json.Marshal(a.Name)
+	}
+
+	type allowedUnsafeSysctlAlias AllowedUnsafeSysctl
+	return json.Marshal(allowedUnsafeSysctlAlias(a))
+}
+
+// ScopedSettings are the settings produced by merging multiple
+// SecurityContextConstraints.
+type ScopedSettings struct {
+	AllowedUnsafeSysctls []AllowedUnsafeSysctl `json:"allowedUnsafeSysctls"`
+	ForbiddenSysctls     []string              `json:"forbiddenSysctls"`
+}
+
+// ScopedResult is the conversion of multiple SecurityContextConstraints into
+// a single settings instance.
+type ScopedResult struct {
+	Settings ScopedSettings
+	// The features of the SecurityContextConstraints that could not be
+	// translated, prefixed by the name of the SecurityContextConstraints
+	Untranslated []string
+}
+
+// Convert reads the SecurityContextConstraints objects, either YAML or JSON,
+// found in data and converts each of them. data can hold multiple YAML
+// documents and lists. The objects of other kinds are ignored.
+func Convert(data []byte) ([]Result, error) {
+	constraints, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Result{}
+	for i := range constraints {
+		result, err := ConvertConstraints(&constraints[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range []string{"users", "groups"} {
+			if hasValue(constraints[i].Fields[field]) {
+				result.Untranslated = append(result.Untranslated,
+					fmt.Sprintf("%s: not translated, the settings apply to all the Pods", field))
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// ConvertScoped reads the SecurityContextConstraints objects found in data,
+// like Convert, and merges them into a single settings instance:
+//
+//   - the allowedUnsafeSysctls of each SecurityContextConstraints are scoped
+//     to the service accounts listed by its users. The ones granted to the
+//     `system:serviceaccounts` or `system:authenticated` groups apply to all
+//     the Pods.
+//   - a forbiddenSysctls entry is kept only when all the
+//     SecurityContextConstraints forbid the sysctls it matches, the policy
+//     cannot scope forbiddenSysctls.
+//
+// The SecurityContextConstraints not granted to any service account through
+// users or groups are skipped, the RBAC grants are not translated.
+func ConvertScoped(data []byte) (ScopedResult, error) {
+	constraints, err := decode(data)
+	if err != nil {
+		return ScopedResult{}, err
+	}
+
+	scoped := ScopedResult{
+		Settings: ScopedSettings{
+			AllowedUnsafeSysctls: []AllowedUnsafeSysctl{},
+			ForbiddenSysctls:     []string{},
+		},
+		Untranslated: []string{},
+	}
+	report := func(name, feature string) {
+		scoped.Untranslated = append(scoped.Untranslated, fmt.Sprintf("%s %s: %s", Kind, name, feature))
+	}
+
+	results := []Result{}
+	unscoped := map[string]bool{}
+	for i := range constraints {
+		result, err := ConvertConstraints(&constraints[i])
+		if err != nil {
+			return ScopedResult{}, err
+		}
+		for _, feature := range result.Untranslated {
+			report(result.Name, feature)
+		}
+
+		everyone, serviceAccounts, untranslated := subjects(&constraints[i])
+		for _, feature := range untranslated {
+			report(result.Name, feature)
+		}
+		if !everyone && len(serviceAccounts) == 0 {
+			report(result.Name, "not granted to any service account by users or groups, the RBAC grants are not translated")
+			continue
+		}
+
+		for _, sysctl := range result.Settings.AllowedUnsafeSysctls {
+			entry := AllowedUnsafeSysctl{
+				Name:        sysctl,
+				Description: fmt.Sprintf("allowed by %s %s", Kind, result.Name),
+			}
+			if !everyone {
+				entry.ServiceAccounts = serviceAccounts
+			} else if unscoped[sysctl] {
+				continue
+			} else {
+				unscoped[sysctl] = true
+			}
+			scoped.Settings.AllowedUnsafeSysctls = append(scoped.Settings.AllowedUnsafeSysctls, entry)
+		}
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		return ScopedResult{}, fmt.Errorf("no %s granted to service accounts found", Kind)
+	}
+
+	// the sysctls allowed to all the Pods don't need scoped entries, they
+	// would be ignored by the policy
+	allowed := []AllowedUnsafeSysctl{}
+	for _, entry := range scoped.Settings.AllowedUnsafeSysctls {
+		if len(entry.ServiceAccounts) == 0 || !unscoped[entry.Name] {
+			allowed = append(allowed, entry)
+		}
+	}
+	sort.SliceStable(allowed, func(i, j int) bool {
+		return allowed[i].Name < allowed[j].Name
+	})
+	scoped.Settings.AllowedUnsafeSysctls = allowed
+
+	for _, result := range results {
+		for _, elem := range result.Settings.ForbiddenSysctls {
+			if forbiddenByAll(elem, results) {
+				scoped.Settings.ForbiddenSysctls = append(scoped.Settings.ForbiddenSysctls, elem)
+			} else {
+				report(result.Name,
+					fmt.Sprintf("forbiddenSysctls entry %s: not forbidden by all the %s", elem, Kind))
+			}
+		}
+	}
+	scoped.Settings.ForbiddenSysctls = convert.WithoutRedundant(scoped.Settings.ForbiddenSysctls)
+
+	return scoped, nil
+}
+
+// ConvertConstraints converts the sysctls of a single
+// SecurityContextConstraints. The users and groups are not reported, their
+// meaning depends on how the SecurityContextConstraints are converted.
+func ConvertConstraints(constraints *SecurityContextConstraints) (Result, error) {
+	allowed := []string{}
+	if err := unmarshalField(constraints, "allowedUnsafeSysctls", &allowed); err != nil {
+		return Result{}, fmt.Errorf("%s %s: %w", Kind, constraints.Metadata.Name, err)
+	}
+	forbidden := []string{}
+	if err := unmarshalField(constraints, "forbiddenSysctls", &forbidden); err != nil {
+		return Result{}, fmt.Errorf("%s %s: %w", Kind, constraints.Metadata.Name, err)
+	}
+
+	settings, untranslated, err := convert.Sysctls(allowed, forbidden)
+	if err != nil {
+		return Result{}, fmt.Errorf("%s %s: %w", Kind, constraints.Metadata.Name, err)
+	}
+	result := Result{
+		Name:         constraints.Metadata.Name,
+		Settings:     settings,
+		Untranslated: untranslated,
+	}
+
+	fields := []string{}
+	for field, value := range constraints.Fields {
+		switch field {
+		case "apiVersion", "kind", "metadata", "allowedUnsafeSysctls", "forbiddenSysctls", "users", "groups":
+			continue
+		}
+		// the SecurityContextConstraints list all their fields, the ones
+		// that are not set are not worth reporting
+		if hasValue(value) {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		result.Untranslated = append(result.Untranslated, fmt.Sprintf("%s: not about sysctls", field))
+	}
+
+	return result, nil
+}
+
+// subjects returns whether the SecurityContextConstraints are granted to all
+// the service accounts, and otherwise the service accounts they are granted
+// to, in the `namespace/name` form. The subjects that cannot be translated
+// are returned too.
+func subjects(constraints *SecurityContextConstraints) (bool, []string, []string) {
+	everyone := false
+	serviceAccounts := []string{}
+	untranslated := []string{}
+
+	for _, group := range constraints.Groups {
+		switch {
+		case group == serviceAccountsGroup || group == authenticatedGroup:
+			everyone = true
+		case strings.HasPrefix(group, serviceAccountsGroup+":"):
+			untranslated = append(untranslated,
+				fmt.Sprintf("groups entry %s: namespace wide grants are not supported, list the service accounts in users",
+					group))
+		default:
+			untranslated = append(untranslated,
+				fmt.Sprintf("groups entry %s: only service accounts are translated", group))
+		}
+	}
+
+	for _, user := range constraints.Users {
+		namespace, name, found := strings.Cut(strings.TrimPrefix(user, serviceAccountUserPrefix), ":")
+		if !strings.HasPrefix(user, serviceAccountUserPrefix) || !found || namespace == "" || name == "" {
+			untranslated = append(untranslated,
+				fmt.Sprintf("users entry %s: only service accounts are translated", user))
+			continue
+		}
+		serviceAccounts = append(serviceAccounts, namespace+"/"+name)
+	}
+	sort.Strings(serviceAccounts)
+
+	return everyone, serviceAccounts, untranslated
+}
+
+// forbiddenByAll returns true when all the sysctls matched by the
+// forbiddenSysctls entry are forbidden by all the results.
+func forbiddenByAll(elem string, results []Result) bool {
+	for _, result := range results {
+		if strings.HasSuffix(elem, "*") {
+			if !coveredByPattern(elem, result.Settings.ForbiddenSysctls) {
+				return false
+			}
+		} else if _, found := convert.ForbiddenBy(elem, result.Settings.ForbiddenSysctls); !found {
+			return false
+		}
+	}
+	return true
+}
+
+// coveredByPattern returns true when the pattern is matched by one of the
+// entries, either the pattern itself or a broader one.
+func coveredByPattern(pattern string, forbidden []string) bool {
+	for _, elem := range forbidden {
+		if elem == pattern ||
+			(strings.HasSuffix(elem, "*") && strings.HasPrefix(pattern, strings.TrimSuffix(elem, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// unmarshalField decodes the given field, when it's set.
+func unmarshalField(constraints *SecurityContextConstraints, field string, value interface{}) error {
+	raw := constraints.Fields[field]
+	if !hasValue(raw) {
+		return nil
+	}
+	if err := json.Unmarshal(raw, value); err != nil {
+		return fmt.Errorf("%s must be a list of strings: %w", field, err)
+	}
+	return nil
+}
+
+// hasValue returns true when the field is set to something other than null,
+// false or an empty list.
+func hasValue(raw json.RawMessage) bool {
+	switch strings.TrimSpace(string(raw)) {
+	case "", "null", "false", "[]":
+		return false
+	}
+	return true
+}
+
+// decode returns the SecurityContextConstraints objects found in data.
+func decode(data []byte) ([]SecurityContextConstraints, error) {
+	objects, err := manifest.DecodeKind(data, Kind)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("no %s found", Kind)
+	}
+
+	constraints := []SecurityContextConstraints{}
+	for _, object := range objects {
+		scc := SecurityContextConstraints{}
+		if err := json.Unmarshal(object, &scc); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(object, &scc.Fields); err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, scc)
+	}
+	return constraints, nil
+}
@@ -0,0 +1,230 @@
+package scc
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/kubewarden/go-policy-template/internal/convert"
+)
+
+func TestConvert(t *testing.T) {
+	data, err := os.ReadFile("../test_data/scc/list.json")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	results, err := Convert(data)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	expected := []Result{
+		{
+			Name: "ipc",
+			Settings: convert.Settings{
+				AllowedUnsafeSysctls: []string{"kernel.msgmax"},
+				ForbiddenSysctls:     []string{"net.*"},
+			},
+			Untranslated: []string{
+				"users: not translated, the settings apply to all the Pods",
+				"groups: not translated, the settings apply to all the Pods",
+			},
+		},
+		{
+			Name: "shm",
+			Settings: convert.Settings{
+				AllowedUnsafeSysctls: []string{"kernel.msgmax", "kernel.shmall"},
+				ForbiddenSysctls:     []string{"net.*"},
+			},
+			Untranslated: []string{
+				"users: not translated, the settings apply to all the Pods",
+			},
+		},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("got %+v instead of %+v", results, expected)
+	}
+}
+
+func TestConvertScoped(t *testing.T) {
+	for _, tcase := range []struct {
+		fixture      string
+		settings     ScopedSettings
+		untranslated []string
+	}{
+		{
+			fixture: "../test_data/scc/sccs.yaml",
+			settings: ScopedSettings{
+				AllowedUnsafeSysctls: []AllowedUnsafeSysctl{
+					{
+						Name:            "kernel.sem",
+						Description:     "allowed by SecurityContextConstraints database",
+						ServiceAccounts: []string{"db/mysql", "db/postgres"},
+					},
+					{
+						Name:            "kernel.shmmax",
+						Description:     "allowed by SecurityContextConstraints database",
+						ServiceAccounts: []string{"db/mysql", "db/postgres"},
+					},
+					{
+						Name:            "net.core.somaxconn",
+						Description:     "allowed by SecurityContextConstraints network-tuning",
+						ServiceAccounts: []string{"ingress/router"},
+					},
+				},
+				// net.ipv4.ip_forward is forbidden by the pattern of
+				// restricted-v2 and explicitly by the others
+				ForbiddenSysctls: []string{"kernel.shm_rmid_forced", "net.ipv4.ip_forward"},
+			},
+			untranslated: []string{
+				"SecurityContextConstraints restricted-v2: allowedCapabilities: not about sysctls",
+				"SecurityContextConstraints restricted-v2: fsGroup: not about sysctls",
+				"SecurityContextConstraints restricted-v2: requiredDropCapabilities: not about sysctls",
+				"SecurityContextConstraints restricted-v2: runAsUser: not about sysctls",
+				"SecurityContextConstraints restricted-v2: seLinuxContext: not about sysctls",
+				"SecurityContextConstraints restricted-v2: supplementalGroups: not about sysctls",
+				"SecurityContextConstraints restricted-v2: volumes: not about sysctls",
+				"SecurityContextConstraints network-tuning: allowedUnsafeSysctls entry net.ipv4.tcp_*: " +
+					"patterns are not supported, list the sysctls explicitly",
+				"SecurityContextConstraints network-tuning: groups entry system:serviceaccounts:monitoring: " +
+					"namespace wide grants are not supported, list the service accounts in users",
+				"SecurityContextConstraints network-tuning: users entry alice: only service accounts are translated",
+				"SecurityContextConstraints rbac-only: not granted to any service account by users or groups, " +
+					"the RBAC grants are not translated",
+				"SecurityContextConstraints restricted-v2: forbiddenSysctls entry net.ipv4.ip_*: " +
+					"not forbidden by all the SecurityContextConstraints",
+			},
+		},
+		{
+			fixture: "../test_data/scc/list.json",
+			settings: ScopedSettings{
+				AllowedUnsafeSysctls: []AllowedUnsafeSysctl{
+					// granted to all the service accounts, the scoped
+					// entry of shm is not needed
+					{
+						Name:        "kernel.msgmax",
+						Description: "allowed by SecurityContextConstraints ipc",
+					},
+					{
+						Name:            "kernel.shmall",
+						Description:     "allowed by SecurityContextConstraints shm",
+						ServiceAccounts: []string{"db/postgres"},
+					},
+				},
+				ForbiddenSysctls: []string{"net.*"},
+			},
+			untranslated: []string{},
+		},
+	} {
+		t.Run(tcase.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(tcase.fixture)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			result, err := ConvertScoped(data)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if !reflect.DeepEqual(result.Settings, tcase.settings) {
+				t.Errorf("got %+v instead of %+v", result.Settings, tcase.settings)
+			}
+			if !reflect.DeepEqual(result.Untranslated, tcase.untranslated) {
+				t.Errorf("got untranslated %q instead of %q", result.Untranslated, tcase.untranslated)
+			}
+		})
+	}
+}
+
+func TestForbiddenSysctlsMerge(t *testing.T) {
+	for _, tcase := range []struct {
+		name      string
+		forbidden [][]string
+		expected  []string
+	}{
+		{
+			name:      "same entries",
+			forbidden: [][]string{{"net.*"}, {"net.*"}},
+			expected:  []string{"net.*"},
+		},
+		{
+			name:      "narrower pattern covered by a broader one",
+			forbidden: [][]string{{"net.ipv4.*"}, {"net.*"}},
+			expected:  []string{"net.ipv4.*"},
+		},
+		{
+			name:      "narrower pattern not covered by a name",
+			forbidden: [][]string{{"net.ipv4.*"}, {"net.ipv4.ip_forward"}},
+			expected:  []string{"net.ipv4.ip_forward"},
+		},
+		{
+			name:      "redundant entries are dropped",
+			forbidden: [][]string{{"*", "net.*"}, {"*"}},
+			expected:  []string{"*"},
+		},
+		{
+			name:      "nothing in common",
+			forbidden: [][]string{{"net.*"}, {"kernel.*"}},
+			expected:  []string{},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			results := []Result{}
+			for _, forbidden := range tcase.forbidden {
+				results = append(results, Result{Settings: convert.Settings{ForbiddenSysctls: forbidden}})
+			}
+
+			merged := []string{}
+			for _, result := range results {
+				for _, elem := range result.Settings.ForbiddenSysctls {
+					if forbiddenByAll(elem, results) {
+						merged = append(merged, elem)
+					}
+				}
+			}
+			merged = convert.WithoutRedundant(merged)
+			if !reflect.DeepEqual(merged, tcase.expected) {
+				t.Errorf("got %v instead of %v", merged, tcase.expected)
+			}
+		})
+	}
+}
+
+func TestConvertScopedErrors(t *testing.T) {
+	for _, tcase := range []struct {
+		name  string
+		data  string
+		error string
+	}{
+		{
+			name: "no SecurityContextConstraints",
+			data: `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: default
+`,
+			error: "no SecurityContextConstraints found",
+		},
+		{
+			name: "not granted to service accounts",
+			data: `
+apiVersion: security.openshift.io/v1
+kind: SecurityContextConstraints
+metadata:
+  name: anyuid
+groups:
+  - system:cluster-admins
+allowedUnsafeSysctls: ["net.core.somaxconn"]
+`,
+			error: "no SecurityContextConstraints granted to service accounts found",
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			_, err := ConvertScoped([]byte(tcase.data))
+			if err == nil || err.Error() != tcase.error {
+				t.Errorf("got error '%v', wanted '%s'", err, tcase.error)
+			}
+		})
+	}
+}
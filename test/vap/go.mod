@@ -0,0 +1,34 @@
+module github.com/kubewarden/go-policy-template/test/vap
+
+go 1.22
+
+toolchain go1.25.5
+
+require (
+	github.com/google/cel-go v0.20.1
+	github.com/kubewarden/go-policy-template v0.0.0
+	github.com/kubewarden/policy-sdk-go v0.12.0
+)
+
+require (
+	github.com/antlr4-go/antlr/v4 v4.13.0 // indirect
+	github.com/deckarep/golang-set/v2 v2.8.0 // indirect
+	github.com/francoispqt/gojay v0.0.0-20181220093123-f2cc13a668ca // indirect
+	github.com/francoispqt/onelog v0.0.0-20190306043706-8c2bb31b10a4 // indirect
+	github.com/go-openapi/strfmt v0.21.3 // indirect
+	github.com/kubewarden/gjson v1.7.2 // indirect
+	github.com/kubewarden/k8s-objects v1.29.0-kw1 // indirect
+	github.com/stoewer/go-strcase v1.2.0 // indirect
+	github.com/tidwall/match v1.0.3 // indirect
+	github.com/tidwall/pretty v1.0.2 // indirect
+	github.com/wapc/wapc-guest-tinygo v0.3.3 // indirect
+	golang.org/x/exp v0.0.0-20230515195305-f3d0a9c9a5cc // indirect
+	golang.org/x/text v0.9.0 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20230803162519-f966b187b2e5 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230803162519-f966b187b2e5 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
+)
+
+replace github.com/kubewarden/go-policy-template => ../..
+
+replace github.com/go-openapi/strfmt => github.com/kubewarden/strfmt v0.1.3
@@ -0,0 +1,187 @@
+// Package vap cross-checks the ValidatingAdmissionPolicy exported by the
+// policy against the policy itself, evaluating its CEL expressions with
+// cel-go. It's a separate module, so that cel-go is not a dependency of the
+// policy.
+package vap
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"github.com/kubewarden/go-policy-template/policy"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+	kubewarden_testing "github.com/kubewarden/policy-sdk-go/testing"
+)
+
+// admittedByValidatingAdmissionPolicy evaluates the exported policy against
+// the request like the API server does, and returns whether the request is
+// admitted.
+func admittedByValidatingAdmissionPolicy(
+	t *testing.T,
+	vap *policy.ValidatingAdmissionPolicy,
+	binding *policy.ValidatingAdmissionPolicyBinding,
+	request *kubewarden_protocol.KubernetesAdmissionRequest,
+) bool {
+	resource := strings.ToLower(request.Kind.Kind) + "s"
+	if request.SubResource != "" {
+		resource += "/" + request.SubResource
+	}
+	matched := false
+	for _, rule := range vap.Spec.MatchConstraints.ResourceRules {
+		if slices.Contains(rule.Operations, request.Operation) && slices.Contains(rule.Resources, resource) {
+			matched = true
+		}
+	}
+	if !matched {
+		return true
+	}
+
+	env, err := cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.Variable("oldObject", cel.DynType),
+		cel.Variable("request", cel.DynType),
+		cel.Variable("variables", cel.MapType(cel.StringType, cel.DynType)),
+		ext.Strings(),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	toMap := func(value interface{}) interface{} {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		return decoded
+	}
+	variables := map[string]interface{}{}
+	activation := map[string]interface{}{
+		"object":    toMap(request.Object),
+		"oldObject": toMap(request.OldObject),
+		"request":   toMap(request),
+		"variables": variables,
+	}
+	evaluate := func(expression string) interface{} {
+		ast, issues := env.Compile(expression)
+		if issues != nil && issues.Err() != nil {
+			t.Fatalf("cannot compile %s: %+v", expression, issues.Err())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		value, _, err := program.Eval(activation)
+		if err != nil {
+			t.Fatalf("cannot evaluate %s: %+v", expression, err)
+		}
+		return value.Value()
+	}
+
+	for _, condition := range vap.Spec.MatchConditions {
+		if evaluate(condition.Expression) != true {
+			return true
+		}
+	}
+	for _, variable := range vap.Spec.Variables {
+		variables[variable.Name] = evaluate(variable.Expression)
+	}
+
+	admitted := true
+	for _, validation := range vap.Spec.Validations {
+		if evaluate(validation.Expression) != true {
+			message, ok := evaluate(validation.MessageExpression).(string)
+			if !ok || message == "" {
+				t.Errorf("messageExpression %s doesn't return a message", validation.MessageExpression)
+			}
+			admitted = false
+		}
+	}
+	return admitted || !slices.Contains(binding.Spec.ValidationActions, "Deny")
+}
+
+func TestExportedValidatingAdmissionPolicyDecisions(t *testing.T) {
+	fixtures, err := filepath.Glob("../../test_data/request-*.json")
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("cannot find the fixtures: %+v", err)
+	}
+
+	for _, settings := range []string{
+		`{}`,
+		`{"allowedUnsafeSysctls": ["net.core.somaxconn"]}`,
+		`{"forbiddenSysctls": ["net.*"]}`,
+		`{"forbiddenSysctls": ["*"], "allowedUnsafeSysctls": ["net.core.somaxconn"]}`,
+		`{"forbiddenSysctls": ["net.core.*"], "allowedUnsafeSysctls": ["net.core.somaxconn"]}`,
+		`{"forbiddenSysctls": ["kernel.shm_rmid_forced"]}`,
+		`{"forbiddenSysctls": ["net.*", {"name": "net.ipv4.*", "action": "warn"}]}`,
+		`{"forbiddenSysctls": [{"name": "kernel.shm_rmid_forced", "action": "log"}, "kernel.*"]}`,
+		`{"allowedUnsafeSysctls": [{"name": "net.core.somaxconn", "serviceAccounts": ["default/default"]}]}`,
+		`{"allowedUnsafeSysctls": [{"name": "net.core.somaxconn", "serviceAccounts": ["default/other"]}]}`,
+		`{"allowedUnsafeSysctls": [{"name": "net.core.somaxconn", "selector": {"matchLabels": {"env": "test"}}}]}`,
+		`{"allowedUnsafeSysctls": [{
+			"name": "net.core.somaxconn",
+			"selector": {"matchExpressions": [{"key": "app", "operator": "NotIn", "values": ["nginx"]}]}
+		}]}`,
+		`{"allowedUnsafeSysctls": [{
+			"name": "net.core.somaxconn",
+			"selector": {"matchExpressions": [
+				{"key": "env", "operator": "Exists"},
+				{"key": "team", "operator": "DoesNotExist"}
+			]}
+		}]}`,
+		`{"forbiddenSysctls": ["*"], "exemptNamespaces": ["default"]}`,
+		`{"forbiddenSysctls": ["*"], "exemptNamespaces": ["d?f[a-z]u*"]}`,
+		`{"forbiddenSysctls": ["*"], "exemptNamespaces": ["kube-*"]}`,
+		`{"forbiddenSysctls": ["net.*"], "enforcementMode": "warn"}`,
+		`{"preset": "strict"}`,
+		`{"preset": "network-tuning"}`,
+	} {
+		for _, fixture := range fixtures {
+			t.Run(settings+" "+fixture, func(t *testing.T) {
+				payload, err := kubewarden_testing.BuildValidationRequestFromFixture(
+					fixture,
+					json.RawMessage(settings))
+				if err != nil {
+					t.Fatalf("unexpected error '%+v'", err)
+				}
+				validationRequest := kubewarden_protocol.ValidationRequest{}
+				if err := json.Unmarshal(payload, &validationRequest); err != nil {
+					t.Fatalf("unexpected error '%+v'", err)
+				}
+
+				responsePayload, err := policy.Validate(payload)
+				if err != nil {
+					t.Fatalf("unexpected error '%+v'", err)
+				}
+				var response kubewarden_protocol.ValidationResponse
+				if err := json.Unmarshal(responsePayload, &response); err != nil {
+					t.Fatalf("unexpected error '%+v'", err)
+				}
+
+				parsed, err := policy.NewSettingsFromValidateSettingsPayload([]byte(settings))
+				if err != nil {
+					t.Fatalf("unexpected error '%+v'", err)
+				}
+				// the warn and log actions are not exported, they don't
+				// change the decisions
+				vap, binding, _, err := parsed.ExportValidatingAdmissionPolicy("sysctl-psp")
+				if err != nil {
+					t.Fatalf("unexpected error '%+v'", err)
+				}
+
+				admitted := admittedByValidatingAdmissionPolicy(t, &vap, &binding, &validationRequest.Request)
+				if admitted != response.Accepted {
+					t.Errorf("got admitted %v from the ValidatingAdmissionPolicy, accepted %v from the policy",
+						admitted, response.Accepted)
+				}
+			})
+		}
+	}
+}